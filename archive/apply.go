@@ -0,0 +1,283 @@
+package archive
+
+import (
+	"ToyDocker/fsutil"
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Apply unpacks the tar diff r into the layer directory dir, writing its whiteouts
+// the way format has them, and returns the number of bytes read.
+// Owners, modes, times, extended attributes, hard links and device nodes are kept.
+func Apply(dir string, r io.Reader, format WhiteoutFormat) (int64, error) {
+	return unpack(dir, r, format, true)
+}
+
+// Untar unpacks the tar archive r into dir like Apply, but .wh. files are ordinary files
+// and nothing is deleted. For archives of files rather than layers.
+func Untar(dir string, r io.Reader) error {
+	_, err := unpack(dir, r, NoWhiteout, false)
+	return err
+}
+
+func unpack(dir string, r io.Reader, format WhiteoutFormat, whiteouts bool) (int64, error) {
+	counter := &countingReader{r: r}
+	tr := tar.NewReader(counter)
+	// paths this diff wrote, an opaque directory keeps them
+	unpacked := make(map[string]bool)
+	// directory times are set last, unpacking their entries changes them
+	var dirs []*tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return counter.n, fmt.Errorf("read tar diff: %v", err)
+		}
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			// the root of the layer
+			if hdr.Typeflag == tar.TypeDir {
+				dirs = append(dirs, hdr)
+			}
+			continue
+		}
+		target, err := safePath(dir, name)
+		if err != nil {
+			return counter.n, err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return counter.n, err
+		}
+
+		base := path.Base(name)
+		if whiteouts && strings.HasPrefix(base, WhiteoutPrefix) {
+			if err := applyWhiteout(dir, name, format, unpacked); err != nil {
+				return counter.n, err
+			}
+			continue
+		}
+
+		if err := unpackEntry(dir, target, hdr, tr); err != nil {
+			return counter.n, fmt.Errorf("unpack %s: %v", hdr.Name, err)
+		}
+		unpacked[name] = true
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr)
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target, err := safePath(dir, path.Clean("/"+dirs[i].Name))
+		if err != nil {
+			return counter.n, err
+		}
+		setTimes(target, dirs[i])
+	}
+	// the padding after the end of the archive counts too
+	if _, err := io.Copy(ioutil.Discard, counter); err != nil {
+		return counter.n, err
+	}
+	return counter.n, nil
+}
+
+// carry out the whiteout at name, an absolute path inside the layer
+func applyWhiteout(dir, name string, format WhiteoutFormat, unpacked map[string]bool) error {
+	parent, base := path.Dir(name), path.Base(name)
+	// the directory holding the whiteout is followed like any other on the way
+	target, err := safePath(dir, name)
+	if err != nil {
+		return err
+	}
+	parentPath := filepath.Dir(target)
+	if base == WhiteoutOpaqueDir {
+		switch format {
+		case OverlayWhiteout:
+			if err := os.MkdirAll(parentPath, 0755); err != nil {
+				return err
+			}
+			return syscall.Setxattr(parentPath, overlayOpaqueXattr, []byte("y"), 0)
+		case NoWhiteout:
+			// drop what the layers below had in the directory
+			entries, err := ioutil.ReadDir(parentPath)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				if !unpacked[path.Join(parent, entry.Name())] {
+					if err := os.RemoveAll(filepath.Join(parentPath, entry.Name())); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+		return createMarker(filepath.Join(parentPath, base))
+	}
+
+	if strings.HasPrefix(base, whiteoutMetaPrefix) {
+		// aufs bookkeeping, nothing to delete
+		return nil
+	}
+	deleted := filepath.Join(parentPath, strings.TrimPrefix(base, WhiteoutPrefix))
+	switch format {
+	case OverlayWhiteout:
+		if err := os.RemoveAll(deleted); err != nil {
+			return err
+		}
+		return syscall.Mknod(deleted, syscall.S_IFCHR, 0)
+	case NoWhiteout:
+		return os.RemoveAll(deleted)
+	}
+	return createMarker(filepath.Join(parentPath, base))
+}
+
+// empty whiteout file, the way aufs marks deletions
+func createMarker(file string) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func unpackEntry(dir, target string, hdr *tar.Header, tr io.Reader) error {
+	// anything in the way goes, except a directory meeting a directory
+	if info, err := os.Lstat(target); err == nil {
+		if !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+	}
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, os.FileMode(mode)); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(mode))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		return applyOwner(target, hdr, os.Symlink(hdr.Linkname, target))
+	case tar.TypeLink:
+		source, err := safePath(dir, path.Clean("/"+hdr.Linkname))
+		if err != nil {
+			return err
+		}
+		// the link shares owner, mode and times with its source
+		return os.Link(source, target)
+	case tar.TypeChar:
+		if err := syscall.Mknod(target, syscall.S_IFCHR|mode, int(mkdev(hdr.Devmajor, hdr.Devminor))); err != nil {
+			return err
+		}
+	case tar.TypeBlock:
+		if err := syscall.Mknod(target, syscall.S_IFBLK|mode, int(mkdev(hdr.Devmajor, hdr.Devminor))); err != nil {
+			return err
+		}
+	case tar.TypeFifo:
+		if err := syscall.Mkfifo(target, mode); err != nil {
+			return err
+		}
+	default:
+		// pax and gnu headers are handled by the reader, other types are not supported
+		return nil
+	}
+	if err := applyOwner(target, hdr, nil); err != nil {
+		return err
+	}
+	// chmod after chown, which clears setuid bits
+	if err := os.Chmod(target, os.FileMode(mode)|setBits(hdr.Mode)); err != nil {
+		return err
+	}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, paxXattrPrefix) {
+			continue
+		}
+		err := syscall.Setxattr(target, strings.TrimPrefix(key, paxXattrPrefix), []byte(value), 0)
+		// filesystems without xattrs, or not allowed to set this namespace
+		if err != nil && err != syscall.ENOTSUP && err != syscall.EPERM {
+			return err
+		}
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		setTimes(target, hdr)
+	}
+	return nil
+}
+
+// chown target as hdr says, unless creating it failed with err already
+func applyOwner(target string, hdr *tar.Header, err error) error {
+	if err != nil {
+		return err
+	}
+	return os.Lchown(target, hdr.Uid, hdr.Gid)
+}
+
+// setuid, setgid and sticky in tar mode bits as os.FileMode bits
+func setBits(mode int64) os.FileMode {
+	var bits os.FileMode
+	if mode&04000 != 0 {
+		bits |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		bits |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		bits |= os.ModeSticky
+	}
+	return bits
+}
+
+func setTimes(target string, hdr *tar.Header) {
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	if hdr.ModTime.IsZero() {
+		return
+	}
+	syscall.UtimesNano(target, []syscall.Timespec{timespec(atime), timespec(hdr.ModTime)})
+}
+
+func timespec(t time.Time) syscall.Timespec {
+	return syscall.NsecToTimespec(t.UnixNano())
+}
+
+func mkdev(major, minor int64) uint64 {
+	return uint64(major&0xfff)<<8 | uint64(minor&0xff) | uint64(minor&^0xff)<<12 | uint64(major&^0xfff)<<32
+}
+
+// path of name, an absolute path inside the layer, below dir. Symlinks on the
+// way are resolved inside dir, like /bin -> usr/bin of a usrmerge image, so
+// the diff can never escape dir through them. The last element is not followed.
+func safePath(dir, name string) (string, error) {
+	return fsutil.ResolveInRoot(dir, name, false)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,512 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// files get this mtime, whole seconds survive every tar format
+var testTime = time.Unix(1700000000, 0)
+
+var formatNames = map[WhiteoutFormat]string{
+	OCIWhiteout:     "oci",
+	OverlayWhiteout: "overlay",
+	NoWhiteout:      "none",
+}
+
+// owners, device nodes and trusted xattrs need root
+func requireRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root")
+	}
+}
+
+// a layer deleting /old, making /opq opaque, and holding a file with an owner and
+// an xattr, two hard links, a symlink and a device node, whiteouts written as format has them
+func makeLayer(t *testing.T, dir string, format WhiteoutFormat) {
+	mkdir(t, dir, "etc", 0755, 0, 0)
+	writeFile(t, dir, "etc/passwd", "root:x:0:0\n", 0640, 1000, 1001)
+	if err := syscall.Setxattr(filepath.Join(dir, "etc/passwd"), "user.mime", []byte("text/plain"), 0); err != nil {
+		t.Skipf("no user xattrs: %v", err)
+	}
+	mkdir(t, dir, "bin", 0755, 0, 0)
+	writeFile(t, dir, "bin/busybox", "#!busybox", 04755, 0, 0)
+	if err := os.Link(filepath.Join(dir, "bin/busybox"), filepath.Join(dir, "bin/sh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("busybox", filepath.Join(dir, "bin/ls")); err != nil {
+		t.Fatal(err)
+	}
+	mkdir(t, dir, "dev", 0755, 0, 0)
+	if err := syscall.Mknod(filepath.Join(dir, "dev/null"), syscall.S_IFCHR|0666, int(mkdev(1, 3))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "dev/null"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	mkdir(t, dir, "opq", 0700, 0, 0)
+	writeFile(t, dir, "opq/new", "new", 0644, 0, 0)
+
+	switch format {
+	case OCIWhiteout:
+		writeFile(t, dir, ".wh.old", "", 0644, 0, 0)
+		writeFile(t, dir, "opq/"+WhiteoutOpaqueDir, "", 0644, 0, 0)
+	case OverlayWhiteout:
+		if err := syscall.Mknod(filepath.Join(dir, "old"), syscall.S_IFCHR, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := syscall.Setxattr(filepath.Join(dir, "opq"), overlayOpaqueXattr, []byte("y"), 0); err != nil {
+			t.Skipf("no trusted xattrs: %v", err)
+		}
+	}
+}
+
+// what makeLayer makes, as describe sees it
+var layerDescription = map[string]string{
+	"/bin":         "dir 0755 0:0",
+	"/bin/busybox": "file 4755 0:0 mtime=1700000000 content=#!busybox",
+	"/bin/ls":      "symlink busybox 0:0",
+	"/bin/sh":      "hardlink /bin/busybox",
+	"/dev":         "dir 0755 0:0",
+	"/dev/null":    "char 1:3 0666 0:0",
+	"/etc":         "dir 0755 0:0",
+	"/etc/passwd":  "file 0640 1000:1001 mtime=1700000000 content=root:x:0:0\n xattr user.mime=text/plain",
+	"/old":         "whiteout",
+	"/opq":         "dir 0700 0:0 opaque",
+	"/opq/new":     "file 0644 0:0 mtime=1700000000 content=new",
+}
+
+func mkdir(t *testing.T, dir, name string, mode os.FileMode, uid, gid int) {
+	file := filepath.Join(dir, name)
+	if err := os.Mkdir(file, mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(file, uid, gid); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string, mode os.FileMode, uid, gid int) {
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(file, uid, gid); err != nil {
+		t.Fatal(err)
+	}
+	perm := mode & 0777
+	if mode&04000 != 0 {
+		perm |= os.ModeSetuid
+	}
+	if err := os.Chmod(file, perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, testTime, testTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// describe the layer in dir independent of how format writes whiteouts:
+// path inside the layer to type, mode, owner, content and xattrs
+func describe(t *testing.T, dir string, format WhiteoutFormat) map[string]string {
+	description := make(map[string]string)
+	opaque := make(map[string]bool)
+	links := make(map[uint64]string)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || file == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, file)
+		name := "/" + filepath.ToSlash(rel)
+		base := filepath.Base(name)
+		stat := info.Sys().(*syscall.Stat_t)
+		owner := fmt.Sprintf("%d:%d", stat.Uid, stat.Gid)
+		perm := fmt.Sprintf("%04o", uint32(info.Mode().Perm())|uint32(stat.Mode&07000))
+
+		if format == OCIWhiteout && base == WhiteoutOpaqueDir {
+			opaque[filepath.Dir(name)] = true
+			return nil
+		}
+		if format == OCIWhiteout && strings.HasPrefix(base, WhiteoutPrefix) {
+			description[filepath.Join(filepath.Dir(name), strings.TrimPrefix(base, WhiteoutPrefix))] = "whiteout"
+			return nil
+		}
+		if format == OverlayWhiteout && isOverlayWhiteout(info) {
+			description[name] = "whiteout"
+			return nil
+		}
+		if format == OverlayWhiteout && info.IsDir() && overlayOpaque(file) {
+			opaque[name] = true
+		}
+
+		var d string
+		switch {
+		case info.IsDir():
+			d = "dir " + perm + " " + owner
+		case info.Mode()&os.ModeSymlink != 0:
+			link, _ := os.Readlink(file)
+			d = "symlink " + link + " " + owner
+		case info.Mode()&os.ModeCharDevice != 0:
+			d = fmt.Sprintf("char %d:%d %s %s", stat.Rdev>>8&0xfff, stat.Rdev&0xff, perm, owner)
+		case info.Mode().IsRegular():
+			if first, ok := links[stat.Ino]; ok && stat.Nlink > 1 {
+				description[name] = "hardlink " + first
+				return nil
+			}
+			links[stat.Ino] = name
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			d = fmt.Sprintf("file %s %s mtime=%d content=%s", perm, owner, info.ModTime().Unix(), content)
+		default:
+			d = "other " + info.Mode().String()
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			hdr := &tar.Header{}
+			readXattrs(file, hdr)
+			var xattrs []string
+			for key, value := range hdr.PAXRecords {
+				xattrs = append(xattrs, strings.TrimPrefix(key, paxXattrPrefix)+"="+value)
+			}
+			sort.Strings(xattrs)
+			for _, xattr := range xattrs {
+				d += " xattr " + xattr
+			}
+		}
+		description[name] = d
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range opaque {
+		description[name] += " opaque"
+	}
+	return description
+}
+
+// a layer of one driver goes through a tar diff into a layer of another unchanged
+func TestRoundTrip(t *testing.T) {
+	requireRoot(t)
+	formats := []WhiteoutFormat{OCIWhiteout, OverlayWhiteout}
+	for _, from := range formats {
+		for _, to := range formats {
+			t.Run(formatNames[from]+"-to-"+formatNames[to], func(t *testing.T) {
+				src, dst := t.TempDir(), t.TempDir()
+				makeLayer(t, src, from)
+				if got := describe(t, src, from); !reflect.DeepEqual(got, layerDescription) {
+					t.Fatalf("source layer is\n%v\nwant\n%v", got, layerDescription)
+				}
+				diff, err := Tar(src, from)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = Apply(dst, diff, to)
+				diff.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := describe(t, dst, to); !reflect.DeepEqual(got, layerDescription) {
+					t.Errorf("applied layer is\n%v\nwant\n%v", got, layerDescription)
+				}
+			})
+		}
+	}
+}
+
+// whatever the layer format, the tar diff has OCI whiteouts and xattrs in PAX records
+func TestTarEntries(t *testing.T) {
+	requireRoot(t)
+	for _, format := range []WhiteoutFormat{OCIWhiteout, OverlayWhiteout} {
+		t.Run(formatNames[format], func(t *testing.T) {
+			dir := t.TempDir()
+			makeLayer(t, dir, format)
+			diff, err := Tar(dir, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer diff.Close()
+			headers := make(map[string]*tar.Header)
+			tr := tar.NewReader(diff)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				headers[strings.TrimSuffix(hdr.Name, "/")] = hdr
+			}
+
+			for _, name := range []string{".wh.old", "opq/" + WhiteoutOpaqueDir} {
+				if hdr, ok := headers[name]; !ok || hdr.Typeflag != tar.TypeReg || hdr.Size != 0 {
+					t.Errorf("no empty whiteout file %s", name)
+				}
+			}
+			if _, ok := headers["old"]; ok {
+				t.Error("the overlay whiteout device is in the diff")
+			}
+			if hdr := headers["opq"]; hdr == nil || hdr.PAXRecords[paxXattrPrefix+overlayOpaqueXattr] != "" {
+				t.Error("overlay xattrs are in the diff")
+			}
+			if hdr := headers["etc/passwd"]; hdr == nil || hdr.PAXRecords[paxXattrPrefix+"user.mime"] != "text/plain" || hdr.Uid != 1000 || hdr.Gid != 1001 {
+				t.Errorf("etc/passwd header %+v lacks owner or xattr", hdr)
+			}
+			if hdr := headers["bin/sh"]; hdr == nil || hdr.Typeflag != tar.TypeLink || hdr.Linkname != "bin/busybox" {
+				t.Errorf("bin/sh header %+v is not a hard link to bin/busybox", hdr)
+			}
+			if hdr := headers["dev/null"]; hdr == nil || hdr.Typeflag != tar.TypeChar || hdr.Devmajor != 1 || hdr.Devminor != 3 {
+				t.Errorf("dev/null header %+v is not char device 1:3", hdr)
+			}
+		})
+	}
+}
+
+// a diff applied onto files already there deletes them through whiteouts and opaque directories,
+// in a flattened layer or by replacing them with overlay whiteouts
+func TestApplyWhiteouts(t *testing.T) {
+	requireRoot(t)
+	for _, format := range []WhiteoutFormat{OverlayWhiteout, NoWhiteout} {
+		t.Run(formatNames[format], func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			makeLayer(t, src, OCIWhiteout)
+			// what the layer had before the diff
+			writeFile(t, dst, "old", "old", 0644, 0, 0)
+			mkdir(t, dst, "opq", 0755, 0, 0)
+			writeFile(t, dst, "opq/hidden", "hidden", 0644, 0, 0)
+			diff, err := Tar(src, OCIWhiteout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Apply(dst, diff, format)
+			diff.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := describe(t, dst, format)
+			switch format {
+			case NoWhiteout:
+				if _, ok := got["/old"]; ok {
+					t.Error("/old was not deleted")
+				}
+				if _, ok := got["/opq/hidden"]; ok {
+					t.Error("/opq/hidden survived the opaque directory")
+				}
+				if _, ok := got["/opq/new"]; !ok {
+					t.Error("/opq/new of the diff itself was removed")
+				}
+			default:
+				if got["/old"] != "whiteout" {
+					t.Errorf("/old is %q, want a whiteout", got["/old"])
+				}
+				if !strings.HasSuffix(got["/opq"], " opaque") {
+					t.Errorf("/opq is %q, want it opaque", got["/opq"])
+				}
+			}
+		})
+	}
+}
+
+// write a tar diff with the given headers, files get the content x
+func diffReader(headers ...*tar.Header) io.Reader {
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		for _, hdr := range headers {
+			if hdr.Typeflag == tar.TypeReg {
+				hdr.Size = 1
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				tw.Write([]byte("x"))
+			}
+		}
+		writer.CloseWithError(tw.Close())
+	}()
+	return reader
+}
+
+// symlinks pointing out of the layer are resolved inside it
+func TestApplySymlinkEscape(t *testing.T) {
+	dst, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dst, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../..", filepath.Join(dst, "up")); err != nil {
+		t.Fatal(err)
+	}
+	diff := diffReader(
+		&tar.Header{Name: "escape/file", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "up/other", Typeflag: tar.TypeReg, Mode: 0644},
+	)
+	if _, err := Apply(dst, diff, OCIWhiteout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "file")); err == nil {
+		t.Error("the diff wrote outside the layer")
+	}
+	for _, name := range []string{filepath.Join(outside, "file"), "other"} {
+		if _, err := os.Lstat(filepath.Join(dst, name)); err != nil {
+			t.Errorf("%s not written inside the layer: %v", name, err)
+		}
+	}
+}
+
+// a layer writing through a symlinked directory of the image, like bin on a
+// usrmerge image, writes into the directory the symlink points to
+func TestApplyThroughSymlinkedDir(t *testing.T) {
+	for _, format := range []WhiteoutFormat{OCIWhiteout, OverlayWhiteout, NoWhiteout} {
+		t.Run(formatNames[format], func(t *testing.T) {
+			if format == OverlayWhiteout {
+				requireRoot(t)
+			}
+			dst := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dst, "usr/bin"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, dst, "usr/bin/old", "old", 0755, os.Geteuid(), os.Getegid())
+			if err := os.Symlink("usr/bin", filepath.Join(dst, "bin")); err != nil {
+				t.Fatal(err)
+			}
+			diff := diffReader(
+				&tar.Header{Name: "bin/foo", Typeflag: tar.TypeReg, Mode: 0755, Uid: os.Geteuid(), Gid: os.Getegid()},
+				&tar.Header{Name: "bin/.wh.old", Typeflag: tar.TypeReg},
+			)
+			if _, err := Apply(dst, diff, format); err != nil {
+				t.Fatal(err)
+			}
+			if info, err := os.Lstat(filepath.Join(dst, "bin")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("bin is no longer a symlink: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dst, "usr/bin/foo")); err != nil {
+				t.Errorf("bin/foo not written to usr/bin: %v", err)
+			}
+			info, err := os.Lstat(filepath.Join(dst, "usr/bin/old"))
+			switch format {
+			case OverlayWhiteout:
+				if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+					t.Errorf("usr/bin/old is not a whiteout: %v", err)
+				}
+			case NoWhiteout:
+				if !os.IsNotExist(err) {
+					t.Errorf("usr/bin/old not deleted: %v", err)
+				}
+			case OCIWhiteout:
+				if _, err := os.Lstat(filepath.Join(dst, "usr/bin/.wh.old")); err != nil {
+					t.Errorf("no whiteout marker in usr/bin: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// build a lower layer with a, dir/b, dir/c and gone/d
+func makeLower(t *testing.T, dir string) {
+	writeFile(t, dir, "a", "a", 0644, 0, 0)
+	mkdir(t, dir, "dir", 0755, 0, 0)
+	writeFile(t, dir, "dir/b", "b", 0644, 0, 0)
+	writeFile(t, dir, "dir/c", "c", 0644, 0, 0)
+	mkdir(t, dir, "gone", 0755, 0, 0)
+	writeFile(t, dir, "gone/d", "d", 0644, 0, 0)
+}
+
+// the changes of a flattened layer, exported and applied to its parent, give the layer
+func TestChangesDirsRoundTrip(t *testing.T) {
+	requireRoot(t)
+	parent, dir, target := t.TempDir(), t.TempDir(), t.TempDir()
+	makeLower(t, parent)
+	makeLower(t, dir)
+	makeLower(t, target)
+	writeFile(t, dir, "dir/b", "changed", 0644, 0, 0)
+	writeFile(t, dir, "dir/new", "new", 0644, 0, 0)
+	if err := os.Remove(filepath.Join(dir, "dir/c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "gone")); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := ChangesDirs(dir, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"C /dir", "C /dir/b", "D /dir/c", "A /dir/new", "D /gone"}
+	if got := changeStrings(changes); !reflect.DeepEqual(got, want) {
+		t.Errorf("changes %v, want %v", got, want)
+	}
+
+	diff, err := ExportChanges(dir, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Apply(target, diff, NoWhiteout)
+	diff.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := describe(t, target, NoWhiteout), describe(t, dir, NoWhiteout); !reflect.DeepEqual(got, want) {
+		t.Errorf("parent with the diff applied is\n%v\nwant\n%v", got, want)
+	}
+}
+
+// an upper layer lists its changes against the layers below through its whiteouts
+func TestChangesLayer(t *testing.T) {
+	requireRoot(t)
+	for _, format := range []WhiteoutFormat{OCIWhiteout, OverlayWhiteout} {
+		t.Run(formatNames[format], func(t *testing.T) {
+			lower, upper := t.TempDir(), t.TempDir()
+			makeLower(t, lower)
+			mkdir(t, upper, "dir", 0755, 0, 0)
+			writeFile(t, upper, "dir/b", "changed", 0644, 0, 0)
+			writeFile(t, upper, "dir/new", "new", 0644, 0, 0)
+			mkdir(t, upper, "gone", 0755, 0, 0)
+			writeFile(t, upper, "gone/d", "again", 0644, 0, 0)
+			switch format {
+			case OCIWhiteout:
+				writeFile(t, upper, ".wh.a", "", 0644, 0, 0)
+				writeFile(t, upper, "gone/"+WhiteoutOpaqueDir, "", 0644, 0, 0)
+			case OverlayWhiteout:
+				if err := syscall.Mknod(filepath.Join(upper, "a"), syscall.S_IFCHR, 0); err != nil {
+					t.Fatal(err)
+				}
+				if err := syscall.Setxattr(filepath.Join(upper, "gone"), overlayOpaqueXattr, []byte("y"), 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			changes, err := ChangesLayer(upper, format, []string{lower})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// below an opaque directory everything is new
+			want := []string{"D /a", "C /dir", "C /dir/b", "A /dir/new", "C /gone", "A /gone/d"}
+			if got := changeStrings(changes); !reflect.DeepEqual(got, want) {
+				t.Errorf("changes %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func changeStrings(changes []Change) []string {
+	var s []string
+	for _, change := range changes {
+		s = append(s, change.String())
+	}
+	return s
+}
@@ -0,0 +1,285 @@
+package archive
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// ChangeKind says what happened to a path in a layer
+type ChangeKind int
+
+const (
+	ChangeModify ChangeKind = iota
+	ChangeAdd
+	ChangeDelete
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdd:
+		return "A"
+	case ChangeDelete:
+		return "D"
+	}
+	return "C"
+}
+
+// Change is one path a layer added, changed or deleted, Path is absolute inside the layer
+type Change struct {
+	Path string
+	Kind ChangeKind
+}
+
+func (c Change) String() string {
+	return c.Kind.String() + " " + c.Path
+}
+
+// ChangesDirs compares the full filesystem dir with parentDir, the one it started from,
+// for drivers that keep no separate upper directory. Empty parentDir means nothing was there.
+// The contents of deleted directories are not listed, only the directory.
+func ChangesDirs(dir, parentDir string) ([]Change, error) {
+	var changes []Change
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := "/" + filepath.ToSlash(rel)
+		if parentDir == "" {
+			changes = append(changes, Change{Path: name, Kind: ChangeAdd})
+			return nil
+		}
+		parentFile := filepath.Join(parentDir, rel)
+		parentInfo, err := os.Lstat(parentFile)
+		if os.IsNotExist(err) {
+			changes = append(changes, Change{Path: name, Kind: ChangeAdd})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if changed(file, info, parentFile, parentInfo) {
+			changes = append(changes, Change{Path: name, Kind: ChangeModify})
+		}
+		return nil
+	})
+	if err != nil || parentDir == "" {
+		return changes, err
+	}
+	err = filepath.Walk(parentDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(parentDir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		current, err := os.Lstat(filepath.Join(dir, rel))
+		if os.IsNotExist(err) {
+			changes = append(changes, Change{Path: "/" + filepath.ToSlash(rel), Kind: ChangeDelete})
+		} else if err != nil {
+			return err
+		} else if current.IsDir() {
+			return nil
+		}
+		// gone, or replaced by something else, which takes its contents along
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// directories with changes inside count as changed, like with an upper directory
+	listed := make(map[string]bool)
+	for _, change := range changes {
+		listed[change.Path] = true
+	}
+	for _, change := range changes {
+		for parent := path.Dir(change.Path); parent != "/"; parent = path.Dir(parent) {
+			if listed[parent] {
+				break
+			}
+			listed[parent] = true
+			changes = append(changes, Change{Path: parent, Kind: ChangeModify})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// ChangesLayer lists the changes of layerDir, a layer holding only what changed and marking
+// deletions with the whiteouts of format. lowerDirs are the layers below, topmost first,
+// they tell added paths from changed ones.
+func ChangesLayer(layerDir string, format WhiteoutFormat, lowerDirs []string) ([]Change, error) {
+	var changes []Change
+	// directories made opaque, nothing below them comes from the lower layers
+	var opaque []string
+	err := filepath.Walk(layerDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(layerDir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := "/" + filepath.ToSlash(rel)
+		base := path.Base(name)
+		if format != OverlayWhiteout && strings.HasPrefix(base, WhiteoutPrefix) {
+			if base == WhiteoutOpaqueDir {
+				opaque = append(opaque, path.Dir(name))
+			} else if !strings.HasPrefix(base, whiteoutMetaPrefix) {
+				deleted := path.Join(path.Dir(name), strings.TrimPrefix(base, WhiteoutPrefix))
+				changes = append(changes, Change{Path: deleted, Kind: ChangeDelete})
+			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if format == OverlayWhiteout && isOverlayWhiteout(info) {
+			changes = append(changes, Change{Path: name, Kind: ChangeDelete})
+			return nil
+		}
+		kind := ChangeAdd
+		if !underAny(name, opaque) && existsInLayers(lowerDirs, name, format) {
+			kind = ChangeModify
+		}
+		changes = append(changes, Change{Path: name, Kind: kind})
+		if info.IsDir() && format == OverlayWhiteout && overlayOpaque(file) {
+			opaque = append(opaque, name)
+		}
+		return nil
+	})
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, err
+}
+
+// report whether name is strictly below one of dirs
+func underAny(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(name, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// report whether name is visible in the stack of lowerDirs, topmost first
+func existsInLayers(lowerDirs []string, name string, format WhiteoutFormat) bool {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	for _, dir := range lowerDirs {
+		// a whiteout or opaque directory on the way hides the layers further down
+		hidden := false
+		current := dir
+		for i, part := range parts {
+			file := filepath.Join(current, part)
+			info, err := os.Lstat(file)
+			if format != OverlayWhiteout {
+				if _, whErr := os.Lstat(filepath.Join(current, WhiteoutPrefix+part)); whErr == nil {
+					return false
+				}
+			}
+			if err != nil {
+				break
+			}
+			if format == OverlayWhiteout && isOverlayWhiteout(info) {
+				return false
+			}
+			if i == len(parts)-1 {
+				return true
+			}
+			if !info.IsDir() {
+				// a file where the directory was replaces it
+				return false
+			}
+			if (format == OverlayWhiteout && overlayOpaque(file)) || format != OverlayWhiteout && exists(filepath.Join(file, WhiteoutOpaqueDir)) {
+				hidden = true
+			}
+			current = file
+		}
+		if hidden {
+			return false
+		}
+	}
+	return false
+}
+
+// report whether info is an overlay whiteout, a 0/0 character device
+func isOverlayWhiteout(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return info.Mode()&os.ModeCharDevice != 0 && ok && stat.Rdev == 0
+}
+
+func exists(file string) bool {
+	_, err := os.Lstat(file)
+	return err == nil
+}
+
+// report whether file differs from parentFile in type, content, owner, mode or times.
+// Directories only change with their own metadata, not their entries.
+func changed(file string, info os.FileInfo, parentFile string, parentInfo os.FileInfo) bool {
+	if info.Mode() != parentInfo.Mode() {
+		return true
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	parentStat, parentOk := parentInfo.Sys().(*syscall.Stat_t)
+	if ok && parentOk && (stat.Uid != parentStat.Uid || stat.Gid != parentStat.Gid || stat.Rdev != parentStat.Rdev) {
+		return true
+	}
+	if info.IsDir() {
+		return false
+	}
+	if info.Size() != parentInfo.Size() || !info.ModTime().Equal(parentInfo.ModTime()) {
+		return true
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, _ := os.Readlink(file)
+		parentLink, _ := os.Readlink(parentFile)
+		return link != parentLink
+	}
+	return false
+}
+
+// ExportChanges streams the changes of the layer directory dir as a tar diff,
+// with OCI whiteouts for the deleted paths
+func ExportChanges(dir string, changes []Change) (io.ReadCloser, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	// changed paths and the directories leading to them
+	wanted := make(map[string]bool)
+	var deleted []string
+	for _, change := range changes {
+		name := strings.TrimPrefix(change.Path, "/")
+		if change.Kind == ChangeDelete {
+			deleted = append(deleted, name)
+		} else {
+			wanted[name] = true
+		}
+		for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+			wanted[parent] = true
+		}
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		include := func(name string) bool {
+			return wanted[name]
+		}
+		writer.CloseWithError(writeTar(dir, NoWhiteout, writer, tarOptions{include: include, deleted: deleted}))
+	}()
+	return reader, nil
+}
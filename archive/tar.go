@@ -0,0 +1,233 @@
+// Package archive turns layer directories into tar diffs and back
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// WhiteoutFormat says how a layer directory marks files deleted from the layers below
+type WhiteoutFormat int
+
+const (
+	// empty .wh.<name> files and .wh..wh..opq markers, like the tar diffs themselves and aufs
+	OCIWhiteout WhiteoutFormat = iota
+	// 0/0 character devices and directories with the trusted.overlay.opaque xattr
+	OverlayWhiteout
+	// flattened layers like vfs ones: deleted files are simply gone
+	NoWhiteout
+)
+
+const (
+	// .wh.<name> in a tar diff deletes <name>
+	WhiteoutPrefix = ".wh."
+	// .wh..wh..opq in a directory hides everything the layers below have in it
+	WhiteoutOpaqueDir = ".wh..wh..opq"
+	// .wh..wh.<name> other than the opaque marker is aufs bookkeeping
+	whiteoutMetaPrefix = ".wh..wh."
+
+	overlayOpaqueXattr = "trusted.overlay.opaque"
+)
+
+// Tar streams the files below dir as a tar diff, turning the whiteouts of format into OCI ones
+func Tar(dir string, format WhiteoutFormat) (io.ReadCloser, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(dir, format, writer, tarOptions{}))
+	}()
+	return reader, nil
+}
+
+// TarPath streams the file or directory src as a tar of plain files,
+// src itself named name in it and whatever is below it under name/
+func TarPath(src, name string) (io.ReadCloser, error) {
+	if _, err := os.Lstat(src); err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(src, NoWhiteout, writer, tarOptions{rebase: name}))
+	}()
+	return reader, nil
+}
+
+// TarFiltered is Tar writing only the names, relative to dir, include accepts.
+// A directory it rejects is skipped with everything below it.
+func TarFiltered(dir string, format WhiteoutFormat, include func(name string) bool) (io.ReadCloser, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(dir, format, writer, tarOptions{include: include}))
+	}()
+	return reader, nil
+}
+
+type tarOptions struct {
+	// only names it accepts are written, all of them when nil
+	include func(name string) bool
+	// names written as whiteouts after the files
+	deleted []string
+	// when set the entries are named below it instead of dir, dir itself is written as it
+	rebase string
+}
+
+// write the files below dir as a tar diff to w
+func writeTar(dir string, format WhiteoutFormat, w io.Writer, opts tarOptions) error {
+	tw := tar.NewWriter(w)
+	// first name of every inode with several links, later ones become hard links to it
+	links := make(map[uint64]string)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || (rel == "." && opts.rebase == "") {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if opts.rebase != "" {
+			name = path.Join(opts.rebase, name)
+		}
+		if opts.include != nil && !opts.include(name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		base := path.Base(name)
+		if strings.HasPrefix(base, whiteoutMetaPrefix) && base != WhiteoutOpaqueDir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		stat, _ := info.Sys().(*syscall.Stat_t)
+
+		if format == OverlayWhiteout && isOverlayWhiteout(info) {
+			return tw.WriteHeader(&tar.Header{
+				Name:     path.Join(path.Dir(name), WhiteoutPrefix+base),
+				Typeflag: tar.TypeReg,
+				ModTime:  info.ModTime(),
+			})
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		// host user names mean nothing in the image
+		hdr.Uname, hdr.Gname = "", ""
+		if info.Mode()&os.ModeSymlink == 0 {
+			if err := readXattrs(file, hdr); err != nil {
+				return err
+			}
+		}
+		if stat != nil && info.Mode().IsRegular() && stat.Nlink > 1 {
+			if first, ok := links[stat.Ino]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				links[stat.Ino] = name
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.IsDir() && format == OverlayWhiteout && overlayOpaque(file) {
+			return tw.WriteHeader(&tar.Header{
+				Name:     path.Join(name, WhiteoutOpaqueDir),
+				Typeflag: tar.TypeReg,
+				ModTime:  info.ModTime(),
+			})
+		}
+		if hdr.Typeflag == tar.TypeReg && hdr.Size > 0 {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range opts.deleted {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     path.Join(path.Dir(name), WhiteoutPrefix+path.Base(name)),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// prefix of extended attributes in PAX records
+const paxXattrPrefix = "SCHILY.xattr."
+
+// add the extended attributes of file to hdr, except overlay's own
+func readXattrs(file string, hdr *tar.Header) error {
+	size, err := syscall.Listxattr(file, nil)
+	if err != nil || size == 0 {
+		// filesystems without xattr support have none
+		return nil
+	}
+	names := make([]byte, size)
+	if size, err = syscall.Listxattr(file, names); err != nil {
+		return nil
+	}
+	for _, name := range strings.Split(string(names[:size]), "\x00") {
+		if name == "" || strings.HasPrefix(name, "trusted.overlay.") {
+			continue
+		}
+		valueSize, err := syscall.Getxattr(file, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, valueSize)
+		if valueSize, err = syscall.Getxattr(file, name, value); err != nil {
+			continue
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords[paxXattrPrefix+name] = string(value[:valueSize])
+	}
+	if hdr.PAXRecords != nil {
+		hdr.Format = tar.FormatPAX
+	}
+	return nil
+}
+
+// report whether overlay marked directory dir opaque
+func overlayOpaque(dir string) bool {
+	value := make([]byte, 1)
+	n, err := syscall.Getxattr(dir, overlayOpaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
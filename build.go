@@ -0,0 +1,744 @@
+package main
+
+import (
+	"ToyDocker/archive"
+	"ToyDocker/builder"
+	"ToyDocker/container"
+	"ToyDocker/fsutil"
+	"ToyDocker/image"
+	"ToyDocker/layer"
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type buildOptions struct {
+	tags []string
+	// Dockerfile to read, <context>/Dockerfile when empty
+	dockerfile string
+	// directory COPY and ADD take their files from
+	contextDir string
+	// --build-arg values for ARG instructions
+	buildArgs map[string]string
+	noCache   bool
+}
+
+// state of a build between two instructions
+type imageBuilder struct {
+	opts buildOptions
+	// image the next instruction builds on, nil before FROM
+	img *image.Image
+	// digest of the base image and the steps so far, steps are cached by it
+	cacheKey string
+	// ARG values before FROM, only FROM sees them
+	metaArgs map[string]string
+	// ARG values declared after FROM
+	args map[string]string
+	// --build-arg values an ARG asked for
+	usedArgs map[string]bool
+}
+
+// build an image from a Dockerfile, every instruction after FROM is a step
+// with an image of its own, RUN, COPY and ADD add a layer
+func buildImage(opts buildOptions) error {
+	var tags []image.Reference
+	for _, tag := range opts.tags {
+		parsed, err := image.ParseReference(tag)
+		if err != nil {
+			return err
+		}
+		tags = append(tags, parsed)
+	}
+	contextDir, err := filepath.Abs(opts.contextDir)
+	if err != nil {
+		return err
+	}
+	opts.contextDir = contextDir
+	if opts.dockerfile == "" {
+		opts.dockerfile = filepath.Join(contextDir, "Dockerfile")
+	}
+	f, err := os.Open(opts.dockerfile)
+	if err != nil {
+		return err
+	}
+	instructions, err := builder.Parse(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if len(instructions) == 0 {
+		return fmt.Errorf("%s has no instructions", opts.dockerfile)
+	}
+
+	b := &imageBuilder{
+		opts:     opts,
+		metaArgs: make(map[string]string),
+		args:     make(map[string]string),
+		usedArgs: make(map[string]bool),
+	}
+	for i, instruction := range instructions {
+		fmt.Printf("Step %d/%d : %s\n", i+1, len(instructions), instruction.Original)
+		if err := b.dispatch(instruction); err != nil {
+			return fmt.Errorf("line %d: %v", instruction.Line, err)
+		}
+	}
+	if b.img == nil || b.img.ID == "" {
+		return fmt.Errorf("%s builds no image", opts.dockerfile)
+	}
+	for name := range opts.buildArgs {
+		if !b.usedArgs[name] {
+			logrus.Warnf("Build arg %s was not used by any ARG", name)
+		}
+	}
+
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Successfully built %s\n", image.ShortID(b.img.ID))
+	for _, tag := range tags {
+		if err := imageStore.Tag(tag, b.img.ID); err != nil {
+			return err
+		}
+		fmt.Printf("Successfully tagged %s\n", tag)
+	}
+	return nil
+}
+
+func (b *imageBuilder) dispatch(instruction builder.Instruction) error {
+	if b.img == nil && instruction.Command != "FROM" && instruction.Command != "ARG" {
+		return fmt.Errorf("%s before FROM", instruction.Command)
+	}
+	if instruction.Command != "COPY" && instruction.Command != "ADD" {
+		for flag := range instruction.Flags {
+			return fmt.Errorf("%s does not support --%s", instruction.Command, flag)
+		}
+	}
+	switch instruction.Command {
+	case "FROM":
+		return b.from(instruction)
+	case "ARG":
+		return b.arg(instruction)
+	case "ENV", "LABEL", "EXPOSE", "USER", "WORKDIR":
+		args, err := builder.Expand(instruction.Args, b.lookup)
+		if err != nil {
+			return err
+		}
+		return b.configStep(instruction.Command, args)
+	case "CMD", "ENTRYPOINT":
+		// the shell running the command expands them
+		return b.configStep(instruction.Command, instruction.Args)
+	case "RUN":
+		return b.run(instruction)
+	case "COPY", "ADD":
+		return b.copy(instruction)
+	}
+	return fmt.Errorf("unknown instruction %s", instruction.Command)
+}
+
+// the value of name for expansion: ENV before ARG
+func (b *imageBuilder) lookup(name string) (string, bool) {
+	if b.img == nil {
+		value, ok := b.metaArgs[name]
+		return value, ok
+	}
+	if b.img.Config != nil {
+		for _, pair := range b.img.Config.Env {
+			if kv := strings.SplitN(pair, "=", 2); kv[0] == name && len(kv) == 2 {
+				return kv[1], true
+			}
+		}
+	}
+	value, ok := b.args[name]
+	return value, ok
+}
+
+func (b *imageBuilder) from(instruction builder.Instruction) error {
+	if b.img != nil {
+		return fmt.Errorf("multi-stage builds are not supported")
+	}
+	fields := strings.Fields(instruction.Args)
+	if len(fields) != 1 && !(len(fields) == 3 && strings.EqualFold(fields[1], "AS")) {
+		return fmt.Errorf("FROM takes IMAGE [AS NAME]")
+	}
+	ref, err := builder.Expand(fields[0], b.lookup)
+	if err != nil {
+		return err
+	}
+	if ref == "scratch" {
+		// no layers yet, the first COPY or ADD makes the base layer
+		b.img = &image.Image{
+			Architecture: runtime.GOARCH,
+			OS:           runtime.GOOS,
+			Config:       &image.Config{},
+			RootFS:       image.RootFS{Type: "layers"},
+		}
+		b.cacheKey = ref
+		return nil
+	}
+	img, err := resolveImage(ref)
+	if err != nil {
+		return err
+	}
+	b.img = img
+	b.cacheKey = img.ID
+	fmt.Printf(" ---> %s\n", image.ShortID(img.ID))
+	return nil
+}
+
+// ARG NAME[=default], --build-arg NAME=value overrides the default
+func (b *imageBuilder) arg(instruction builder.Instruction) error {
+	kv := strings.SplitN(instruction.Args, "=", 2)
+	name := strings.TrimSpace(kv[0])
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("ARG takes NAME[=default]")
+	}
+	value, ok := "", false
+	if len(kv) == 2 {
+		expanded, err := builder.Expand(kv[1], b.lookup)
+		if err != nil {
+			return err
+		}
+		value, ok = strings.Trim(expanded, `"`), true
+	} else if b.img != nil {
+		// declared again after FROM it keeps the value from before
+		value, ok = b.metaArgs[name]
+	}
+	if buildArg, given := b.opts.buildArgs[name]; given {
+		value, ok = buildArg, true
+		b.usedArgs[name] = true
+	}
+	if !ok {
+		return nil
+	}
+	if b.img == nil {
+		b.metaArgs[name] = value
+	} else {
+		b.args[name] = value
+	}
+	return nil
+}
+
+// a step only changing the image config
+func (b *imageBuilder) configStep(command, args string) error {
+	config := b.img.Config.Copy()
+	if command == "WORKDIR" && !path.IsAbs(args) {
+		workdir := config.WorkingDir
+		if workdir == "" {
+			workdir = "/"
+		}
+		args = path.Join(workdir, args)
+	}
+	if err := image.ApplyChange(config, command+" "+args); err != nil {
+		return err
+	}
+	createdBy := "/bin/sh -c #(nop) " + command + " " + args
+	key := b.stepKey(createdBy)
+	if hit, err := b.useCache(key); hit || err != nil {
+		return err
+	}
+	return b.commit(key, config, nil, createdBy)
+}
+
+// run the command in a container of the image so far and keep what it changed as a layer
+func (b *imageBuilder) run(instruction builder.Instruction) error {
+	if len(b.img.RootFS.DiffIDs) == 0 {
+		return fmt.Errorf("RUN needs an image with a filesystem, COPY or ADD one first")
+	}
+	argv := image.ParseCommand(instruction.Args)
+	env := b.runEnv()
+	// ARG values change what the command does, they are not in the image
+	key := b.stepKey("RUN " + strings.Join(argv, " ") + "\n" + strings.Join(env, "\n"))
+	if hit, err := b.useCache(key); hit || err != nil {
+		return err
+	}
+
+	id, err := container.GenerateID()
+	if err != nil {
+		return err
+	}
+	name := "build_" + container.ShortID(id)
+	// the entrypoint of the image does not apply to RUN
+	args := append(config.args(), "run", "-ti", "-name", name, "-entrypoint=")
+	for _, pair := range env {
+		args = append(args, "-e", pair)
+	}
+	if b.img.Config != nil && b.img.Config.WorkingDir != "" {
+		args = append(args, "-w", b.img.Config.WorkingDir)
+	}
+	if b.img.Config != nil && b.img.Config.User != "" {
+		args = append(args, "-u", b.img.Config.User)
+	}
+	args = append(args, strings.TrimPrefix(b.img.ID, "sha256:"))
+	cmd := exec.Command("/proc/self/exe", append(args, argv...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	runErr := cmd.Run()
+
+	containerInfo, err := containerStore.Resolve(name)
+	if err != nil {
+		if runErr != nil {
+			return fmt.Errorf("run %s: %v", instruction.Args, runErr)
+		}
+		return err
+	}
+	defer func() {
+		if err := removeContainer(containerInfo, true); err != nil {
+			logrus.Errorf("Remove build container %s error %v", name, err)
+		}
+	}()
+	if containerInfo.Status != container.EXIT {
+		return fmt.Errorf("the command '%s' did not run to completion, container is %s", instruction.Args, containerInfo.Status)
+	}
+	if containerInfo.ExitCode != 0 {
+		return fmt.Errorf("the command '%s' returned a non-zero code: %d", instruction.Args, containerInfo.ExitCode)
+	}
+
+	driver, _, imageLayer, err := containerImageLayer(containerInfo)
+	if err != nil {
+		return err
+	}
+	l, err := registerDiff(driver, containerInfo.Id, imageLayer.CacheID, imageLayer.ChainID)
+	if err != nil {
+		return fmt.Errorf("store changes of RUN: %v", err)
+	}
+	return b.commit(key, b.img.Config.Copy(), l, "/bin/sh -c "+instruction.Args)
+}
+
+// environment of RUN: the image's, and the ARG values it does not set
+func (b *imageBuilder) runEnv() []string {
+	var env []string
+	if b.img.Config != nil {
+		env = append(env, b.img.Config.Env...)
+	}
+	var names []string
+	for name := range b.args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !b.envSets(name) {
+			env = append(env, name+"="+b.args[name])
+		}
+	}
+	return env
+}
+
+// report whether the image environment sets name
+func (b *imageBuilder) envSets(name string) bool {
+	if b.img.Config == nil {
+		return false
+	}
+	for _, pair := range b.img.Config.Env {
+		if strings.SplitN(pair, "=", 2)[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// COPY and ADD [--chown=UID[:GID]] SRC... DEST, sources are relative to the build context.
+// ADD unpacks local tar archives, gzipped or not, into DEST.
+func (b *imageBuilder) copy(instruction builder.Instruction) error {
+	owner := &tarOwner{}
+	for flag, value := range instruction.Flags {
+		if flag != "chown" {
+			return fmt.Errorf("%s does not support --%s", instruction.Command, flag)
+		}
+		var err error
+		if owner, err = parseOwner(value); err != nil {
+			return err
+		}
+	}
+	words := builder.SplitArgs(instruction.Args)
+	if len(words) < 2 {
+		return fmt.Errorf("%s takes SRC... DEST", instruction.Command)
+	}
+	for i := range words {
+		expanded, err := builder.Expand(words[i], b.lookup)
+		if err != nil {
+			return err
+		}
+		words[i] = expanded
+	}
+	sources, dest := words[:len(words)-1], words[len(words)-1]
+	destIsDir := strings.HasSuffix(dest, "/") || dest == "." || dest == ".."
+	if !path.IsAbs(dest) {
+		workdir := "/"
+		if b.img.Config != nil && b.img.Config.WorkingDir != "" {
+			workdir = b.img.Config.WorkingDir
+		}
+		dest = path.Join(workdir, dest)
+	}
+
+	var matches []copySource
+	for _, source := range sources {
+		if strings.Contains(source, "://") {
+			return fmt.Errorf("%s of remote URLs is not supported", instruction.Command)
+		}
+		// .. cannot lead out of the context
+		pattern := filepath.Join(b.opts.contextDir, filepath.Clean("/"+source))
+		found, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		if len(found) == 0 {
+			return fmt.Errorf("%s: no such file in the build context", source)
+		}
+		for _, match := range found {
+			rel, err := filepath.Rel(b.opts.contextDir, match)
+			if err != nil {
+				return err
+			}
+			// nor can symlinks, they resolve as if the context were /
+			resolved, err := fsutil.ResolveInRoot(b.opts.contextDir, rel, true)
+			if err != nil {
+				return fmt.Errorf("%s: %v", source, err)
+			}
+			if _, err := os.Lstat(resolved); err != nil {
+				return fmt.Errorf("%s: %s is not in the build context", source, rel)
+			}
+			matches = append(matches, copySource{path: resolved, rel: filepath.ToSlash(rel)})
+		}
+	}
+	if len(matches) > 1 && !destIsDir {
+		return fmt.Errorf("with several sources the destination %s must be a directory ending in /", dest)
+	}
+
+	// the files go into the cache key with their contents
+	digester := sha256.New()
+	for _, match := range matches {
+		if err := hashSource(digester, match.path, match.rel); err != nil {
+			return err
+		}
+	}
+	contentDigest := hex.EncodeToString(digester.Sum(nil))
+	createdBy := fmt.Sprintf("/bin/sh -c #(nop) %s %s:%s in %s", instruction.Command, owner, contentDigest, dest)
+	key := b.stepKey(fmt.Sprintf("%s %t", createdBy, destIsDir))
+	if hit, err := b.useCache(key); hit || err != nil {
+		return err
+	}
+
+	l, err := b.layerFrom(func(root string) error {
+		for _, match := range matches {
+			extract := instruction.Command == "ADD" && isArchive(match.path)
+			if err := copyIntoRoot(root, match.path, path.Base(match.rel), dest, destIsDir, extract, owner); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return b.commit(key, b.img.Config.Copy(), l, createdBy)
+}
+
+// make a layer on top of the image so far from what fill changes in its mounted rootfs.
+// The caller holds a reference on the layer.
+func (b *imageBuilder) layerFrom(fill func(root string) error) (*layer.Layer, error) {
+	driver, err := getStorageDriver()
+	if err != nil {
+		return nil, err
+	}
+	parentChainID, parentCacheID := "", ""
+	if len(b.img.RootFS.DiffIDs) > 0 {
+		parentLayer, err := topLayer(b.img)
+		if err != nil {
+			return nil, err
+		}
+		parentChainID, parentCacheID = parentLayer.ChainID, parentLayer.CacheID
+	}
+	id, err := container.GenerateID()
+	if err != nil {
+		return nil, err
+	}
+	if parentCacheID == "" {
+		err = driver.Create(id, "")
+	} else {
+		err = driver.CreateReadWrite(id, parentCacheID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := driver.Remove(id); err != nil {
+			logrus.Errorf("Remove build layer error %v", err)
+		}
+	}()
+	root, err := driver.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	err = fill(root)
+	if putErr := driver.Put(id); err == nil {
+		err = putErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return registerDiff(driver, id, parentCacheID, parentChainID)
+}
+
+// a file or directory of the build context COPY and ADD take
+type copySource struct {
+	// where it is on the host, symlinks resolved inside the context
+	path string
+	// as matched in the context, its base names the copy
+	rel string
+}
+
+// write what a copy of src depends on to h: names below name, modes, owners, link targets
+// and contents. Not times, an unchanged file touched or checked out again is still cached.
+func hashSource(h io.Writer, src, name string) error {
+	return filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		// the size of a directory depends on its history, not on its entries
+		size := int64(0)
+		if info.Mode().IsRegular() {
+			size = info.Size()
+		}
+		uid, gid := uint32(0), uint32(0)
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = stat.Uid, stat.Gid
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00%d:%d\x00%s\x00%d\x00",
+			path.Join(name, filepath.ToSlash(rel)), uint32(info.Mode()), uid, gid, link, size)
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(h, f)
+		return err
+	})
+}
+
+// copy src from the host into the rootfs at root, named name, as dest or, when destIsDir, into it.
+// The contents of directories are copied, archives unpacked when extract is set.
+func copyIntoRoot(root, src, name, dest string, destIsDir, extract bool, owner *tarOwner) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	var reader io.ReadCloser
+	var dir string
+	if destIsDir || info.IsDir() || extract {
+		// symlinks in the image resolve inside it
+		if dir, err = fsutil.ResolveInRoot(root, dest, true); err != nil {
+			return err
+		}
+		switch {
+		case extract:
+			f, err := os.Open(src)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			unzipped, err := maybeGunzip(f)
+			if err != nil {
+				return err
+			}
+			reader = io.NopCloser(unzipped)
+		case info.IsDir():
+			reader, err = archive.TarPath(src, ".")
+		default:
+			reader, err = archive.TarPath(src, name)
+		}
+	} else {
+		target, resolveErr := fsutil.ResolveInRoot(root, dest, true)
+		if resolveErr != nil {
+			return resolveErr
+		}
+		dir = filepath.Dir(target)
+		reader, err = archive.TarPath(src, filepath.Base(target))
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// files from the host belong to root, or --chown, archives keep their owners
+	if !extract || owner.set {
+		reader = owner.apply(reader)
+		defer reader.Close()
+	}
+	return archive.Untar(dir, reader)
+}
+
+// report whether file is a tar archive, gzipped or not
+func isArchive(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	reader, err := maybeGunzip(f)
+	if err != nil {
+		return false
+	}
+	_, err = tar.NewReader(reader).Next()
+	return err == nil
+}
+
+// owner COPY and ADD give files, root unless --chown says otherwise
+type tarOwner struct {
+	uid, gid int
+	// given with --chown
+	set bool
+}
+
+// --chown=UID[:GID], numeric, the group defaults to the uid
+func parseOwner(value string) (*tarOwner, error) {
+	parts := strings.SplitN(value, ":", 2)
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("--chown=%s: only numeric ids are supported", value)
+	}
+	gid := uid
+	if len(parts) == 2 {
+		if gid, err = strconv.Atoi(parts[1]); err != nil {
+			return nil, fmt.Errorf("--chown=%s: only numeric ids are supported", value)
+		}
+	}
+	return &tarOwner{uid: uid, gid: gid, set: true}, nil
+}
+
+func (o *tarOwner) String() string {
+	return fmt.Sprintf("%d:%d", o.uid, o.gid)
+}
+
+// the tar stream r with every entry owned by o
+func (o *tarOwner) apply(r io.Reader) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(writer)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				writer.CloseWithError(tw.Close())
+				return
+			}
+			if err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+			hdr.Uid, hdr.Gid = o.uid, o.gid
+			hdr.Uname, hdr.Gname = "", ""
+			if err := tw.WriteHeader(hdr); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return reader
+}
+
+// the cache key of step on top of the build so far
+func (b *imageBuilder) stepKey(step string) string {
+	return image.Digest([]byte(b.cacheKey + "\n" + step))
+}
+
+// continue from the image a step with key made before, unless caching is off
+func (b *imageBuilder) useCache(key string) (bool, error) {
+	if b.opts.noCache {
+		return false, nil
+	}
+	imageStore, err := getImageStore()
+	if err != nil {
+		return false, err
+	}
+	img, err := imageStore.CachedImage(key)
+	if err != nil || img == nil {
+		return false, err
+	}
+	b.img = img
+	b.cacheKey = key
+	fmt.Printf(" ---> Using cache\n ---> %s\n", image.ShortID(img.ID))
+	return true, nil
+}
+
+// record the image of a step: the one so far with config and, unless nil, layer l on top.
+// The caller holds a reference on l, the image takes it over.
+func (b *imageBuilder) commit(key string, config *image.Config, l *layer.Layer, createdBy string) error {
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return err
+	}
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+	diffIDs := append([]string{}, b.img.RootFS.DiffIDs...)
+	if l != nil {
+		diffIDs = append(diffIDs, l.DiffID)
+	} else if len(diffIDs) > 0 {
+		// the new image holds the same layers
+		if _, err := layerStore.Acquire(layer.ChainIDs(diffIDs)); err != nil {
+			return err
+		}
+	}
+	created := time.Now().UTC()
+	img := &image.Image{
+		Created:      created,
+		Parent:       b.img.ID,
+		Author:       b.img.Author,
+		Architecture: b.img.Architecture,
+		OS:           b.img.OS,
+		Config:       config,
+		RootFS:       image.RootFS{Type: "layers", DiffIDs: diffIDs},
+		History: append(append([]image.History{}, b.img.History...), image.History{
+			Created:    created,
+			CreatedBy:  createdBy,
+			EmptyLayer: l == nil,
+		}),
+	}
+	if err := storeImage(img, ""); err != nil {
+		return err
+	}
+	if err := imageStore.CacheImage(key, img.ID); err != nil {
+		logrus.Errorf("Record build cache error %v", err)
+	}
+	b.img = img
+	b.cacheKey = key
+	fmt.Printf(" ---> %s\n", image.ShortID(img.ID))
+	return nil
+}
@@ -0,0 +1,162 @@
+// Package builder reads Dockerfiles
+package builder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Instruction is one line of a Dockerfile, continuation lines joined
+type Instruction struct {
+	// upper case, like RUN
+	Command string
+	// everything after the command and its flags
+	Args string
+	// --name=value options before the arguments, like COPY --chown=1:1
+	Flags map[string]string
+	// the instruction as written, on one line
+	Original string
+	// line it starts on
+	Line int
+}
+
+// Parse splits a Dockerfile into its instructions.
+// Lines ending in \ go on on the next one, lines starting with # are comments.
+func Parse(r io.Reader) ([]Instruction, error) {
+	var instructions []Instruction
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	start := 0
+	var current []string
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		// comments may sit between continuation lines too
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(current) == 0 {
+			start = lineNumber
+		}
+		if strings.HasSuffix(line, "\\") {
+			current = append(current, strings.TrimSpace(strings.TrimSuffix(line, "\\")))
+			continue
+		}
+		current = append(current, line)
+		instruction, err := parseInstruction(strings.Join(current, " "), start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+		current = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(current) > 0 {
+		return nil, fmt.Errorf("line %d: instruction ends in a line continuation", start)
+	}
+	return instructions, nil
+}
+
+func parseInstruction(line string, lineNumber int) (Instruction, error) {
+	fields := strings.SplitN(line, " ", 2)
+	instruction := Instruction{
+		Command:  strings.ToUpper(fields[0]),
+		Flags:    make(map[string]string),
+		Original: line,
+		Line:     lineNumber,
+	}
+	args := ""
+	if len(fields) == 2 {
+		args = strings.TrimSpace(fields[1])
+	}
+	for strings.HasPrefix(args, "--") {
+		fields := strings.SplitN(args, " ", 2)
+		flag := strings.SplitN(strings.TrimPrefix(fields[0], "--"), "=", 2)
+		if len(flag) != 2 {
+			return instruction, fmt.Errorf("line %d: flag --%s needs a value", lineNumber, flag[0])
+		}
+		instruction.Flags[flag[0]] = flag[1]
+		args = ""
+		if len(fields) == 2 {
+			args = strings.TrimSpace(fields[1])
+		}
+	}
+	instruction.Args = args
+	return instruction, nil
+}
+
+// SplitArgs splits the arguments of COPY and ADD: a JSON array, for names with spaces, or words
+func SplitArgs(args string) []string {
+	var words []string
+	if strings.HasPrefix(args, "[") && json.Unmarshal([]byte(args), &words) == nil {
+		return words
+	}
+	return strings.Fields(args)
+}
+
+// Expand replaces $NAME, ${NAME}, ${NAME:-default} and ${NAME:+alternative} in word
+// with what lookup finds. Unknown names expand to nothing, \$ is a literal $.
+func Expand(word string, lookup func(name string) (string, bool)) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		if c == '\\' && i+1 < len(word) && word[i+1] == '$' {
+			out.WriteByte('$')
+			i++
+			continue
+		}
+		if c != '$' || i+1 == len(word) {
+			out.WriteByte(c)
+			continue
+		}
+		if word[i+1] == '{' {
+			end := strings.IndexByte(word[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("missing } in %s", word)
+			}
+			expr := word[i+2 : i+end]
+			i += end
+			name, modifier, operand := expr, "", ""
+			if colon := strings.Index(expr, ":"); colon >= 0 {
+				name, operand = expr[:colon], expr[colon+1:]
+				if operand == "" || (operand[0] != '-' && operand[0] != '+') {
+					return "", fmt.Errorf("unsupported modifier in ${%s}", expr)
+				}
+				modifier, operand = operand[:1], operand[1:]
+			}
+			value, ok := lookup(name)
+			switch {
+			case modifier == "-" && (!ok || value == ""):
+				value = operand
+			case modifier == "+" && ok && value != "":
+				value = operand
+			case modifier == "+":
+				value = ""
+			}
+			out.WriteString(value)
+			continue
+		}
+		end := i + 1
+		for end < len(word) && isNameChar(word[end]) {
+			end++
+		}
+		if end == i+1 {
+			out.WriteByte(c)
+			continue
+		}
+		value, _ := lookup(word[i+1 : end])
+		out.WriteString(value)
+		i = end - 1
+	}
+	return out.String(), nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
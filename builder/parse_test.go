@@ -0,0 +1,109 @@
+package builder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	dockerfile := `# syntax comment
+FROM busybox AS base
+
+run echo a \
+    && echo b
+# a comment between continuation lines
+COPY --chown=1000:1000 --from=x a.txt \
+# ignored
+  /app/
+CMD ["sh", "-c", "echo hi"]
+`
+	instructions, err := Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Instruction{
+		{Command: "FROM", Args: "busybox AS base", Flags: map[string]string{}, Original: "FROM busybox AS base", Line: 2},
+		{Command: "RUN", Args: "echo a && echo b", Flags: map[string]string{}, Original: "run echo a && echo b", Line: 4},
+		{
+			Command:  "COPY",
+			Args:     "a.txt /app/",
+			Flags:    map[string]string{"chown": "1000:1000", "from": "x"},
+			Original: "COPY --chown=1000:1000 --from=x a.txt /app/",
+			Line:     7,
+		},
+		{Command: "CMD", Args: `["sh", "-c", "echo hi"]`, Flags: map[string]string{}, Original: `CMD ["sh", "-c", "echo hi"]`, Line: 10},
+	}
+	if !reflect.DeepEqual(instructions, want) {
+		t.Errorf("parsed\n%+v\nwant\n%+v", instructions, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, dockerfile := range []string{
+		"FROM busybox\nRUN echo \\\n",
+		"COPY --chown a /\n",
+	} {
+		if _, err := Parse(strings.NewReader(dockerfile)); err == nil {
+			t.Errorf("%q parsed", dockerfile)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{"a.txt b.txt /app/", []string{"a.txt", "b.txt", "/app/"}},
+		{`["my file.txt", "/app dir/"]`, []string{"my file.txt", "/app dir/"}},
+		// not valid JSON, taken as words
+		{`[a.txt /app/`, []string{"[a.txt", "/app/"}},
+	}
+	for _, test := range tests {
+		if got := SplitArgs(test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"HOME": "/root", "EMPTY": "", "V": "1"}
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+	tests := []struct {
+		word    string
+		want    string
+		invalid bool
+	}{
+		{word: "$HOME/bin", want: "/root/bin"},
+		{word: "${HOME}bin", want: "/rootbin"},
+		{word: "v$V.$V", want: "v1.1"},
+		{word: "$MISSING-x", want: "-x"},
+		{word: "${MISSING:-default}", want: "default"},
+		{word: "${EMPTY:-default}", want: "default"},
+		{word: "${HOME:-default}", want: "/root"},
+		{word: "${HOME:+set}", want: "set"},
+		{word: "${EMPTY:+set}", want: ""},
+		{word: "${MISSING:+set}", want: ""},
+		{word: `\$HOME`, want: "$HOME"},
+		{word: "cost: 5$", want: "cost: 5$"},
+		{word: "$ HOME", want: "$ HOME"},
+		{word: "${HOME", invalid: true},
+		{word: "${HOME:=x}", invalid: true},
+	}
+	for _, test := range tests {
+		got, err := Expand(test.word, lookup)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("Expand(%q) = %q, want an error", test.word, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("Expand(%q) = %q, %v, want %q", test.word, got, err, test.want)
+		}
+	}
+}
@@ -18,12 +18,15 @@ func NewCgroupManager(path string) *CgroupManager {
 	}
 }
 
-// add PID into each cgroup
+// add PID into each cgroup, returning the first failure
 func (c *CgroupManager) Apply(pid int) error {
+	var firstErr error
 	for _, subSysIns := range subsystems.SubsystemsIns {
-		subSysIns.Apply(c.Path, pid)
+		if err := subSysIns.Apply(c.Path, pid); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return nil
+	return firstErr
 }
 
 // setup cgroup resource limit
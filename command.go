@@ -3,22 +3,19 @@ package main
 import (
 	"ToyDocker/cgroups/subsystems"
 	"ToyDocker/container"
+	"ToyDocker/logdriver"
 	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"io/ioutil"
-	"math/rand"
 	"os"
-	"strconv"
 	"strings"
-	"text/tabwriter"
-	"time"
 )
 
 var runCommand = cli.Command{
 	Name:  "run",
-	Usage: "Create a container with namespace and cgroups limit",
+	Usage: "Create a container with namespace and cgroups limit, run [OPTIONS] IMAGE [COMMAND...], the image config supplies what is not given",
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "ti",
@@ -46,6 +43,43 @@ var runCommand = cli.Command{
 			Name:  "name",
 			Usage: "container name",
 		},
+		cli.StringFlag{
+			Name:  "log-driver",
+			Usage: "log driver: " + strings.Join(logdriver.Names(), ", "),
+			Value: logdriver.DefaultDriver,
+		},
+		cli.StringSliceFlag{
+			Name:  "log-opt",
+			Usage: "log driver option, key=value",
+		},
+		cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "set a container label, key=value",
+		},
+		cli.StringSliceFlag{
+			Name:  "label-file",
+			Usage: "read labels from a file of key=value lines",
+		},
+		cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "set an environment variable, KEY=value, or KEY to pass on ours",
+		},
+		cli.StringFlag{
+			Name:  "w",
+			Usage: "working directory in the container",
+		},
+		cli.StringFlag{
+			Name:  "u",
+			Usage: "user[:group] to run as, names or ids",
+		},
+		cli.StringFlag{
+			Name:  "entrypoint",
+			Usage: "run this instead of the image entrypoint, the image command is dropped, empty clears it",
+		},
+		cli.StringSliceFlag{
+			Name:  "expose",
+			Usage: "expose a port on top of the ones of the image, PORT[/PROTOCOL]",
+		},
 	},
 	/*
 		1. judge if params has command
@@ -54,13 +88,14 @@ var runCommand = cli.Command{
 	*/
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
-			return fmt.Errorf("Missing container command")
+			return fmt.Errorf("Missing image")
 		}
 
 		// send volume to Run()
 		volume := ctx.String("v")
+		imageRef := ctx.Args().Get(0)
 		var cmdArray []string
-		for _, arg := range ctx.Args() {
+		for _, arg := range ctx.Args().Tail() {
 			cmdArray = append(cmdArray, arg)
 		}
 
@@ -78,16 +113,111 @@ var runCommand = cli.Command{
 
 		logrus.Infof("createTty %v", tty)
 		containerName := ctx.String("name")
-		Run(tty, cmdArray, resource, volume, containerName)
+
+		logDriver := ctx.String("log-driver")
+		logOpts, err := parseKeyValues(ctx.StringSlice("log-opt"))
+		if err != nil {
+			return fmt.Errorf("invalid log-opt: %v", err)
+		}
+		// fail now rather than in the logger once the container is running
+		driver, err := logdriver.New(logDriver, logdriver.Info{Options: logOpts, LogPath: container.ContainerLogFile})
+		if err != nil {
+			return err
+		}
+		driver.Close()
+
+		labels, err := readLabels(ctx.StringSlice("label-file"), ctx.StringSlice("label"))
+		if err != nil {
+			return err
+		}
+
+		process := container.ProcessConfig{
+			Args: cmdArray,
+			Env:  parseEnv(ctx.StringSlice("e")),
+			Dir:  ctx.String("w"),
+			User: ctx.String("u"),
+		}
+		// nil keeps the entrypoint of the image
+		var entrypoint []string
+		if ctx.IsSet("entrypoint") {
+			entrypoint = []string{}
+			if value := ctx.String("entrypoint"); value != "" {
+				entrypoint = []string{value}
+			}
+		}
+		Run(tty, imageRef, process, entrypoint, ctx.StringSlice("expose"), resource, volume, containerName, logDriver, logOpts, labels)
 		return nil
 	},
 }
 
 var listCommand = cli.Command{
 	Name:  "ps",
-	Usage: "list all containers",
+	Usage: "list containers",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "a",
+			Usage: "show all containers, not only running ones",
+		},
+		cli.BoolFlag{
+			Name:  "q",
+			Usage: "only print container ids",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter",
+			Usage: "filter output: status=, name=, label=, ancestor=, exited=",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "print containers using a Go template, or json",
+		},
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "do not truncate ids and commands",
+		},
+		cli.BoolFlag{
+			Name:  "cleanup",
+			Usage: "unmount the filesystems of containers found dead",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		filters, err := parseFilters(ctx.StringSlice("filter"))
+		if err != nil {
+			return err
+		}
+		return ListContainers(psOptions{
+			all:     ctx.Bool("a"),
+			quiet:   ctx.Bool("q"),
+			filters: filters,
+			format:  ctx.String("format"),
+			noTrunc: ctx.Bool("no-trunc"),
+			cleanup: ctx.Bool("cleanup"),
+		})
+	},
+}
+
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "print the record of a container as json",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "cleanup",
+			Usage: "unmount the filesystems of the container if it is found dead",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
-		ListContainers()
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerInfo, err := containerStore.Resolve(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		containerInfo = reconcileContainer(containerInfo, ctx.Bool("cleanup"))
+		content, err := json.MarshalIndent(containerInfo, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
 		return nil
 	},
 }
@@ -111,179 +241,457 @@ var initCommand = cli.Command{
 var logCommand = cli.Command{
 	Name:  "logs",
 	Usage: "print logs of container",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "follow log output",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
 			return fmt.Errorf("Please input your container name")
 		}
-		containerName := ctx.Args().Get(0)
-		logContainer(containerName)
-		return nil
+		containerInfo, err := containerStore.Resolve(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		follow := ctx.Bool("f")
+		return logContainer(containerInfo, follow)
+	},
+}
+
+var loggerCommand = cli.Command{
+	Name:   "logger",
+	Usage:  "copy container output into its log driver, called internally",
+	Hidden: true,
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("Missing container id")
+		}
+		return runLogger(ctx.Args().Get(0))
+	},
+}
+
+var renameCommand = cli.Command{
+	Name:  "rename",
+	Usage: "rename a container, toy-docker rename OLD NEW",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 2 {
+			return fmt.Errorf("Missing container name and new name")
+		}
+		containerInfo, err := containerStore.Resolve(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		return containerStore.Rename(containerInfo.Id, ctx.Args().Get(1))
+	},
+}
+
+var removeCommand = cli.Command{
+	Name:  "rm",
+	Usage: "remove containers, toy-docker rm [-f] CONTAINER... or rm --filter label=key=value",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "kill running containers before removing them",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter",
+			Usage: "also remove the containers matching the filter, e.g. label=team=infra",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		filters, err := parseFilters(ctx.StringSlice("filter"))
+		if err != nil {
+			return err
+		}
+		if len(ctx.Args()) < 1 && len(filters) == 0 {
+			return fmt.Errorf("Missing container name or filter")
+		}
+		return removeContainers(ctx.Args(), filters, ctx.Bool("f"))
+	},
+}
+
+var pruneCommand = cli.Command{
+	Name:  "prune",
+	Usage: "remove all stopped containers",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "filter",
+			Usage: "only remove the containers matching the filter, e.g. label=team=infra",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		filters, err := parseFilters(ctx.StringSlice("filter"))
+		if err != nil {
+			return err
+		}
+		return pruneContainers(filters)
 	},
 }
 
 var commitCommand = cli.Command{
 	Name:  "commit",
-	Usage: "commit a container into image",
+	Usage: "commit a container into image, commit [-m MSG] [-a AUTHOR] [-c CHANGE] CONTAINER IMAGE[:TAG]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "m",
+			Usage: "commit message",
+		},
+		cli.StringFlag{
+			Name:  "a",
+			Usage: "author",
+		},
+		cli.StringSliceFlag{
+			Name:  "c",
+			Usage: "apply a CMD, ENTRYPOINT, ENV, EXPOSE, LABEL, USER or WORKDIR instruction to the image config",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 2 {
 			return fmt.Errorf("Missing container name and image name")
 		}
-		containerName := ctx.Args().Get(0)
+		containerInfo, err := containerStore.Resolve(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
 		imageName := ctx.Args().Get(1)
-		commitContainer(containerName, imageName)
-		return nil
+		return commitContainer(containerInfo, imageName, commitOptions{
+			message: ctx.String("m"),
+			author:  ctx.String("a"),
+			changes: ctx.StringSlice("c"),
+		})
 	},
 }
 
-func ListContainers() {
-
-	// search for container info
-	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, "")
-	dirUrl = dirUrl[:len(dirUrl)-1]
-	// read all info in that dir
-	files, err := ioutil.ReadDir(dirUrl)
-	if err != nil {
-		logrus.Errorf("Read dir %s error %v", dirUrl, err)
-		return
-	}
+var diffCommand = cli.Command{
+	Name:  "diff",
+	Usage: "list the files a container added (A), changed (C) and deleted (D), diff CONTAINER",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerInfo, err := containerStore.Resolve(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		return diffContainer(containerInfo)
+	},
+}
 
-	var containers []*container.ContainerInfo
+var copyCommand = cli.Command{
+	Name:  "cp",
+	Usage: "copy files between a container and the host, cp CONTAINER:PATH HOSTPATH|- or cp HOSTPATH|- CONTAINER:PATH",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 2 {
+			return fmt.Errorf("Missing source and destination")
+		}
+		return copyFiles(ctx.Args().Get(0), ctx.Args().Get(1))
+	},
+}
 
-	// iterate all the files
-	for _, file := range files {
-		tmpContainer, err := getContainerInfo(file)
+var buildCommand = cli.Command{
+	Name:  "build",
+	Usage: "build an image from a Dockerfile, build [-t NAME] [-f Dockerfile] [--build-arg KEY=VALUE] [--no-cache] CONTEXT",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "t",
+			Usage: "name and tag the image as NAME[:TAG]",
+		},
+		cli.StringFlag{
+			Name:  "f",
+			Usage: "Dockerfile, CONTEXT/Dockerfile by default",
+		},
+		cli.StringSliceFlag{
+			Name:  "build-arg",
+			Usage: "value of an ARG, KEY=VALUE",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "run every step again instead of using the images of earlier builds",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("Missing build context")
+		}
+		buildArgs, err := parseKeyValues(ctx.StringSlice("build-arg"))
 		if err != nil {
-			logrus.Errorf("Get container info error %v", err)
-			continue
+			return err
 		}
-		containers = append(containers, tmpContainer)
-	}
+		return buildImage(buildOptions{
+			tags:       ctx.StringSlice("t"),
+			dockerfile: ctx.String("f"),
+			contextDir: ctx.Args().Get(0),
+			buildArgs:  buildArgs,
+			noCache:    ctx.Bool("no-cache"),
+		})
+	},
+}
 
-	// use tabwriter.NewWriter() to print print container info
-	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	// output info
-	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
-	for _, item := range containers {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
-			item.Id,
-			item.Name,
-			item.Pid,
-			item.Status,
-			item.Command,
-			item.CreateTime)
-	}
-	if err := w.Flush(); err != nil {
-		logrus.Errorf("Flush error %v", err)
-		return
-	}
+var imagesCommand = cli.Command{
+	Name:  "images",
+	Usage: "list images",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "q",
+			Usage: "only print image ids",
+		},
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "do not truncate image ids",
+		},
+		cli.BoolFlag{
+			Name:  "a",
+			Usage: "also list the untagged images of build steps",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		return listImages(ctx.Bool("q"), ctx.Bool("no-trunc"), ctx.Bool("a"))
+	},
 }
 
-func getContainerInfo(file os.FileInfo) (*container.ContainerInfo, error) {
-	// get file name
-	containerName := file.Name()
-	// generate path by name
-	configFileDir := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	configFileDir = configFileDir + container.ConfigName
-	// read info in config.json
-	content, err := ioutil.ReadFile(configFileDir)
-	if err != nil {
-		logrus.Errorf("Read file %s error %v", configFileDir, err)
-		return nil, err
-	}
-	var containerInfo container.ContainerInfo
-	// json to containerInfo object
-	if err := json.Unmarshal(content, &configFileDir); err != nil {
-		logrus.Errorf("Json unmarshal error %v", err)
-		return nil, err
-	}
+var historyCommand = cli.Command{
+	Name:  "history",
+	Usage: "show the steps an image was made by, history [-no-trunc] IMAGE",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "do not truncate image ids and commands",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return imageHistory(ctx.Args().Get(0), ctx.Bool("no-trunc"))
+	},
+}
 
-	return &containerInfo, nil
+var imageCommand = cli.Command{
+	Name:  "image",
+	Usage: "manage images",
+	Subcommands: []cli.Command{
+		{
+			Name:  "inspect",
+			Usage: "print the config, layers and size of images as json, image inspect IMAGE...",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("Missing image name")
+				}
+				return inspectImages(ctx.Args())
+			},
+		},
+	},
 }
 
-func recordContainerInfo(containerPID int, cmdArray []string, containerName string) (string, error) {
-	// generate id for container
-	id := randStringBytes(10)
-	//create time for container
-	createTime := time.Now().Format("2023-11-11 11:04:05")
-	command := strings.Join(cmdArray, "")
+var removeImageCommand = cli.Command{
+	Name:  "rmi",
+	Usage: "remove images, rmi [-f] IMAGE...",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "remove images with several tags by id",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return removeImages(ctx.Args(), ctx.Bool("f"))
+	},
+}
 
-	// if don't specify name, use id as name
-	if containerName == "" {
-		containerName = id
-	}
+var tagCommand = cli.Command{
+	Name:  "tag",
+	Usage: "give an image another name, tag SOURCE TARGET",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 2 {
+			return fmt.Errorf("Missing source or target image name")
+		}
+		return tagImage(ctx.Args().Get(0), ctx.Args().Get(1))
+	},
+}
 
-	// generate struct
-	containerInfo := &container.ContainerInfo{
-		Id:         id,
-		Pid:        strconv.Itoa(containerPID),
-		Command:    command,
-		CreateTime: createTime,
-		Status:     container.RUNNING,
-		Name:       containerName,
-	}
+var saveCommand = cli.Command{
+	Name:  "save",
+	Usage: "write images to an OCI image layout tar, save IMAGE... -o FILE",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Usage: "write to this file instead of stdout",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return saveImages(ctx.Args(), ctx.String("o"))
+	},
+}
 
-	// make it json serialization
-	jsonBytes, err := json.Marshal(containerInfo)
-	if err != nil {
-		logrus.Errorf("Record container info error %v", err)
-		return "", err
-	}
-	jsonStr := string(jsonBytes)
-
-	// path of container info
-	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	// if the path doesn't exist, create it.
-	if err := os.MkdirAll(dirUrl, 0622); err != nil {
-		logrus.Errorf("Mkdir dir %s error %v", dirUrl, err)
-		return "", err
-	}
-	fileName := dirUrl + "/" + container.ConfigName
-	// create config file
-	file, err := os.Create(fileName)
-	defer file.Close()
-	if err != nil {
-		logrus.Errorf("Create file %s error %v", fileName, err)
-		return "", err
-	}
-	// write data into json file
-	if _, err := file.WriteString(jsonStr); err != nil {
-		logrus.Errorf("File write string error %v", err)
-		return "", err
-	}
+var loadCommand = cli.Command{
+	Name:  "load",
+	Usage: "load images from an OCI image layout or docker save tar, load -i FILE",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "i",
+			Usage: "read from this file instead of stdin",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		return loadImages(ctx.String("i"))
+	},
+}
+
+var exportCommand = cli.Command{
+	Name:  "export",
+	Usage: "write the filesystem of a container as a tar, export CONTAINER -o FILE",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Usage: "write to this file instead of stdout",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		containerInfo, err := containerStore.Resolve(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		return exportContainer(containerInfo, ctx.String("o"))
+	},
+}
 
-	return containerName, nil
+var importCommand = cli.Command{
+	Name:  "import",
+	Usage: "create an image from a rootfs tar, import [-m MSG] [-c CHANGE] FILE|- [IMAGE[:TAG]]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "m",
+			Usage: "commit message",
+		},
+		cli.StringSliceFlag{
+			Name:  "c",
+			Usage: "apply a CMD, ENTRYPOINT, ENV, EXPOSE, LABEL, USER or WORKDIR instruction to the image config",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("Missing rootfs tar")
+		}
+		return importImage(ctx.Args().Get(0), ctx.Args().Get(1), ctx.String("m"), ctx.StringSlice("c"))
+	},
 }
 
-func deleteContainerInfo(containerId string) {
-	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerId)
-	if err := os.RemoveAll(dirUrl); err != nil {
-		logrus.Errorf("Remove dir %s error %v", dirUrl, err)
+var pullCommand = cli.Command{
+	Name:  "pull",
+	Usage: "fetch an image from a registry, pull [HOST[:PORT]/]NAME[:TAG]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "creds",
+			Usage: "USER:PASSWORD for the registry",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return pullImage(ctx.Args().Get(0), ctx.String("creds"))
+	},
+}
+
+var pushCommand = cli.Command{
+	Name:  "push",
+	Usage: "upload an image to the registry its name points at, push HOST[:PORT]/NAME[:TAG]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "creds",
+			Usage: "USER:PASSWORD for the registry",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return pushImage(ctx.Args().Get(0), ctx.String("creds"))
+	},
+}
+
+var infoCommand = cli.Command{
+	Name:  "info",
+	Usage: "show the storage driver in use",
+	Action: func(ctx *cli.Context) error {
+		driver, err := getStorageDriver()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Storage Driver: %s\n", driver)
+		for _, pair := range driver.Status() {
+			fmt.Printf(" %s: %s\n", pair[0], pair[1])
+		}
+		return nil
+	},
+}
+
+// parse key=value pairs given on the command line
+func parseKeyValues(pairs []string) (map[string]string, error) {
+	values := make(map[string]string)
+	if err := addKeyValues(values, pairs); err != nil {
+		return nil, err
 	}
+	return values, nil
 }
 
-func logContainer(containerName string) {
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	logFileLocation := dirURL + container.ContainerLogFile
-	file, err := os.Open(logFileLocation)
-	defer file.Close()
-	if err != nil {
-		logrus.Errorf("Log container open file %s error %v", logFileLocation, err)
-		return
+func addKeyValues(values map[string]string, pairs []string) error {
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("%s is not in key=value format", pair)
+		}
+		values[kv[0]] = kv[1]
 	}
-	content, err := ioutil.ReadAll(file)
-	if err != nil {
-		logrus.Errorf("Log container read file %s error %v", logFileLocation, err)
-		return
+	return nil
+}
+
+// -e values as KEY=value pairs, a bare KEY takes our own value and is dropped when we have none
+func parseEnv(values []string) []string {
+	var env []string
+	for _, value := range values {
+		if strings.Contains(value, "=") {
+			env = append(env, value)
+		} else if own, ok := os.LookupEnv(value); ok {
+			env = append(env, value+"="+own)
+		}
 	}
-	fmt.Fprint(os.Stdout, string(content))
+	return env
 }
 
-func randStringBytes(n int) string {
-	letterBytes := "1234567890"
-	rand.Seed(time.Now().UnixNano())
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+// collect labels from label files, then from --label which wins on conflicts.
+// label files hold one key=value per line, blank lines and # comments are skipped.
+func readLabels(labelFiles, labelPairs []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, labelFile := range labelFiles {
+		content, err := ioutil.ReadFile(labelFile)
+		if err != nil {
+			return nil, fmt.Errorf("read label file %s error %v", labelFile, err)
+		}
+		var pairs []string
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			pairs = append(pairs, line)
+		}
+		if err := addKeyValues(labels, pairs); err != nil {
+			return nil, fmt.Errorf("invalid label in %s: %v", labelFile, err)
+		}
+	}
+	if err := addKeyValues(labels, labelPairs); err != nil {
+		return nil, fmt.Errorf("invalid label: %v", err)
 	}
-	return string(b)
+	return labels, nil
 }
@@ -1,16 +1,85 @@
 package main
 
 import (
-	"github.com/sirupsen/logrus"
-	"os/exec"
+	"ToyDocker/container"
+	"ToyDocker/graphdriver"
+	"ToyDocker/image"
+	"ToyDocker/layer"
+	"fmt"
+	"time"
 )
 
-func commitContainer(containerName, imageName string) {
-	mntUrl := "/root/mnt"
+type commitOptions struct {
+	message string
+	author  string
+	// Dockerfile instructions applied to the config, like "CMD [\"sh\"]"
+	changes []string
+}
+
+// record the changes a container made to its image as a new image imageName
+func commitContainer(containerInfo *container.ContainerInfo, imageName string, opts commitOptions) error {
+	if _, err := image.ParseReference(imageName); err != nil {
+		return err
+	}
+	driver, parent, parentLayer, err := containerImageLayer(containerInfo)
+	if err != nil {
+		return err
+	}
+	config := parent.Config.Copy()
+	for _, change := range opts.changes {
+		if err := image.ApplyChange(config, change); err != nil {
+			return err
+		}
+	}
+
+	// only the write layer, the rest is shared with the parent image
+	l, err := registerDiff(driver, containerInfo.Id, parentLayer.CacheID, parentLayer.ChainID)
+	if err != nil {
+		return fmt.Errorf("store diff of container %s: %v", containerInfo.Name, err)
+	}
 
-	imageTar := "/root/" + imageName + ".tar"
+	created := time.Now().UTC()
+	img := &image.Image{
+		Created:      created,
+		Parent:       parent.ID,
+		Author:       opts.author,
+		Architecture: parent.Architecture,
+		OS:           parent.OS,
+		Comment:      opts.message,
+		Config:       config,
+		RootFS: image.RootFS{
+			Type:    "layers",
+			DiffIDs: append(append([]string{}, parent.RootFS.DiffIDs...), l.DiffID),
+		},
+		History: append(append([]image.History{}, parent.History...), image.History{
+			Created:   created,
+			CreatedBy: containerInfo.Command,
+			Author:    opts.author,
+			Comment:   opts.message,
+		}),
+	}
+	if err := storeImage(img, imageName); err != nil {
+		return err
+	}
+	fmt.Println(img.ID)
+	return nil
+}
 
-	if _, err := exec.Command("tar", "-czf", imageTar, "-C", mntUrl, ".").CombinedOutput(); err != nil {
-		logrus.Errorf("Tar folder %s error")
+// store what layer id of driver changes on top of the image layer with parentCacheID
+// and parentChainID, both empty for none, as an image layer. The caller holds a reference on it.
+func registerDiff(driver graphdriver.Driver, id, parentCacheID, parentChainID string) (*layer.Layer, error) {
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return nil, err
+	}
+	diff, err := driver.Diff(id, parentCacheID)
+	if err != nil {
+		return nil, err
+	}
+	l, err := layerStore.Register(diff, parentChainID)
+	if closeErr := diff.Close(); err == nil && closeErr != nil {
+		layerStore.Release(l.ChainID)
+		err = closeErr
 	}
+	return l, err
 }
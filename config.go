@@ -0,0 +1,98 @@
+package main
+
+import (
+	"ToyDocker/graphdriver"
+	"ToyDocker/store"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// read when --config is not given, it is fine for it to be missing
+const defaultConfigFile = "/etc/toy-docker/config.json"
+
+// settings every path derives from, read from the config file
+// and overridden by the global flags
+type daemonConfig struct {
+	// data root: layers, the base image tarball, committed images
+	Root string `json:"root"`
+	// exec root: container records and their logs
+	ExecRoot string `json:"exec-root"`
+	// picked automatically when empty
+	StorageDriver string `json:"storage-driver"`
+	// registries spoken to over plain http, besides the ones on localhost
+	InsecureRegistries []string `json:"insecure-registries"`
+}
+
+var config = daemonConfig{
+	Root:     graphdriver.DefaultRoot,
+	ExecRoot: store.DefaultRoot,
+}
+
+// commands toy-docker runs itself: init inside the container's namespaces and
+// the logger, which get their settings as flags from the parent process
+var internalCommands = map[string]bool{
+	"init":   true,
+	"logger": true,
+}
+
+// load the config file, apply the global flags and point everything at the result.
+// Internal commands only take the flags, and leave migration to the parent.
+func loadConfig(ctx *cli.Context) error {
+	internal := internalCommands[ctx.Args().First()]
+	path := ctx.GlobalString("config")
+	content, err := ioutil.ReadFile(path)
+	switch {
+	case internal:
+	case err == nil:
+		if err := json.Unmarshal(content, &config); err != nil {
+			return fmt.Errorf("parse config file %s: %v", path, err)
+		}
+	case os.IsNotExist(err) && !ctx.GlobalIsSet("config"):
+	default:
+		return fmt.Errorf("read config file: %v", err)
+	}
+
+	for flag, value := range map[string]*string{
+		"root":           &config.Root,
+		"exec-root":      &config.ExecRoot,
+		"storage-driver": &config.StorageDriver,
+	} {
+		if ctx.GlobalIsSet(flag) {
+			*value = ctx.GlobalString(flag)
+		}
+	}
+	for _, dir := range []*string{&config.Root, &config.ExecRoot} {
+		if *dir == "" {
+			return fmt.Errorf("root and exec-root must not be empty")
+		}
+		abs, err := filepath.Abs(*dir)
+		if err != nil {
+			return err
+		}
+		*dir = abs
+	}
+
+	containerStore = store.New(config.ExecRoot)
+	if internal {
+		return nil
+	}
+	// records of toy-docker versions before the store
+	if err := containerStore.MigrateLegacy(); err != nil {
+		logrus.Errorf("Migrate container records error %v", err)
+	}
+	return nil
+}
+
+// global flags handing the settings on to a child toy-docker process
+func (c daemonConfig) args() []string {
+	return []string{
+		"--root=" + c.Root,
+		"--exec-root=" + c.ExecRoot,
+		"--storage-driver=" + c.StorageDriver,
+	}
+}
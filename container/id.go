@@ -0,0 +1,27 @@
+package container
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+)
+
+// length of the id shown by ps
+const ShortIDLength = 12
+
+// generate a 256-bit random container id in hex
+func GenerateID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// the short form of id shown to users
+func ShortID(id string) string {
+	if len(id) > ShortIDLength {
+		return id[:ShortIDLength]
+	}
+	return id
+}
@@ -1,6 +1,7 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
@@ -11,16 +12,55 @@ import (
 	"syscall"
 )
 
+// PATH of containers whose image sets none
+const DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
 func RunContainerInitProcess() error {
-	cmdArray := readUserCommand()
-	if cmdArray == nil || len(cmdArray) == 0 {
+	process, err := readProcessConfig()
+	if err != nil {
+		return err
+	}
+	if len(process.Args) == 0 {
 		return fmt.Errorf("Run container get user command error, cmdArray is nil")
 	}
 
 	setUpMount()
 
+	env := process.Env
+	if lookupEnv(env, "PATH") == "" {
+		env = append(env, "PATH="+DefaultPath)
+	}
+	if term := os.Getenv("TERM"); term != "" && lookupEnv(env, "TERM") == "" {
+		env = append(env, "TERM="+term)
+	}
+	// exec.LookPath searches our own PATH, make it the container's
+	os.Setenv("PATH", lookupEnv(env, "PATH"))
+
+	if process.Dir != "" {
+		if err := os.MkdirAll(process.Dir, 0755); err != nil {
+			return fmt.Errorf("create working directory %s: %v", process.Dir, err)
+		}
+		if err := syscall.Chdir(process.Dir); err != nil {
+			return fmt.Errorf("chdir %s: %v", process.Dir, err)
+		}
+	}
+	home := "/root"
+	if process.User != "" {
+		user, err := lookupUser(process.User)
+		if err != nil {
+			return err
+		}
+		if err := user.switchTo(); err != nil {
+			return fmt.Errorf("switch to user %s: %v", process.User, err)
+		}
+		home = user.home
+	}
+	if lookupEnv(env, "HOME") == "" {
+		env = append(env, "HOME="+home)
+	}
+
 	// call exec.LooPath: find path of cmd in system's PATH
-	path, err := exec.LookPath(cmdArray[0])
+	path, err := exec.LookPath(process.Args[0])
 	if err != nil {
 		logrus.Errorf(err.Error())
 	}
@@ -30,24 +70,36 @@ func RunContainerInitProcess() error {
 	// It will overwrite the image, data stack and other information of the current process,
 	// including PID, which will be overwritten by the process to be run
 
-	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
+	if err := syscall.Exec(path, process.Args, env); err != nil {
 		logrus.Errorf(err.Error())
 	}
 	return nil
 }
 
-func readUserCommand() []string {
+func readProcessConfig() (*ProcessConfig, error) {
 	// uintptr(3) is the fd whose index is 3
 	pipe := os.NewFile(uintptr(3), "pipe")
 	defer pipe.Close()
 	msg, err := ioutil.ReadAll(pipe)
 	if err != nil {
-		logrus.Errorf("init read pipe error %v", err)
-		return nil
+		return nil, fmt.Errorf("init read pipe error %v", err)
 	}
-	msgStr := string(msg)
+	var process ProcessConfig
+	if err := json.Unmarshal(msg, &process); err != nil {
+		return nil, fmt.Errorf("init decode process error %v", err)
+	}
+	return &process, nil
+}
 
-	return strings.Split(msgStr, " ")
+// value of key in a list of KEY=value pairs, the last one wins
+func lookupEnv(env []string, key string) string {
+	value := ""
+	for _, pair := range env {
+		if strings.HasPrefix(pair, key+"=") {
+			value = strings.TrimPrefix(pair, key+"=")
+		}
+	}
+	return value
 }
 
 func setUpMount() {
@@ -0,0 +1,14 @@
+package container
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bind mount source at target
+func bindMount(source, target string) error {
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind mount %s at %s: %v", source, target, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package container
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+var (
+	nameAdjectives = []string{
+		"admiring", "agitated", "amazing", "angry", "awesome", "beautiful", "blissful",
+		"bold", "boring", "brave", "busy", "charming", "clever", "compassionate",
+		"competent", "confident", "cool", "cranky", "crazy", "dazzling", "determined",
+		"distracted", "dreamy", "eager", "ecstatic", "elastic", "elated", "elegant",
+		"eloquent", "epic", "exciting", "fervent", "festive", "flamboyant", "focused",
+		"friendly", "frosty", "funny", "gallant", "gifted", "goofy", "gracious", "great",
+		"happy", "hardcore", "heuristic", "hopeful", "hungry", "infallible", "inspiring",
+		"intelligent", "interesting", "jolly", "jovial", "keen", "kind", "laughing",
+		"loving", "lucid", "magical", "modest", "musing", "mystifying", "naughty",
+		"nervous", "nice", "nifty", "nostalgic", "objective", "optimistic", "peaceful",
+		"pedantic", "pensive", "practical", "priceless", "quirky", "quizzical",
+		"relaxed", "reverent", "romantic", "sad", "serene", "sharp", "silly", "sleepy",
+		"stoic", "strange", "stupefied", "suspicious", "sweet", "tender", "thirsty",
+		"trusting", "unruffled", "upbeat", "vibrant", "vigilant", "vigorous",
+		"wizardly", "wonderful", "xenodochial", "youthful", "zealous", "zen",
+	}
+	nameSurnames = []string{
+		"agnesi", "albattani", "allen", "almeida", "archimedes", "ardinghelli",
+		"babbage", "banach", "bardeen", "bartik", "bell", "bhabha", "blackwell", "bohr",
+		"booth", "borg", "bose", "brahmagupta", "brattain", "brown", "carson", "cerf",
+		"chandrasekhar", "colden", "cori", "cray", "curie", "darwin", "davinci",
+		"diffie", "dijkstra", "dubinsky", "easley", "edison", "einstein", "elion",
+		"engelbart", "euclid", "euler", "fermat", "fermi", "feynman", "franklin",
+		"galileo", "gates", "goldberg", "goldstine", "goldwasser", "golick", "goodall",
+		"hamilton", "hawking", "heisenberg", "hermann", "hodgkin", "hofstadter",
+		"hopper", "hugle", "hypatia", "jackson", "jang", "jennings", "jepsen",
+		"joliot", "jones", "kalam", "kapitsa", "keller", "kepler", "khorana",
+		"kilby", "kirch", "knuth", "kowalevski", "lalande", "lamarr", "lamport",
+		"leakey", "leavitt", "lewin", "liskov", "lovelace", "lumiere", "mahavira",
+		"mayer", "mccarthy", "mcclintock", "mclean", "meitner", "mendel", "merkle",
+		"minsky", "mirzakhani", "morse", "murdock", "newton", "nightingale", "nobel",
+		"noether", "northcutt", "noyce", "pare", "pascal", "pasteur", "payne",
+		"perlman", "pike", "poincare", "ptolemy", "raman", "ramanujan", "ride",
+		"ritchie", "roentgen", "rosalind", "saha", "sammet", "shannon", "shockley",
+		"sinoussi", "snyder", "spence", "stallman", "swanson", "swartz", "tesla",
+		"thompson", "torvalds", "turing", "varahamihira", "visvesvaraya", "volhard",
+		"wescoff", "wiles", "williams", "wilson", "wing", "wozniak", "wright",
+		"yalow", "yonath",
+	}
+)
+
+// check that name is usable as a container name
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid container name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// generate a readable name like "focused_turing".
+// retry > 0 appends a number, for when the plain names keep colliding.
+func GenerateName(retry int) string {
+	name := randomItem(nameAdjectives) + "_" + randomItem(nameSurnames)
+	if retry > 0 {
+		name = fmt.Sprintf("%s%d", name, retry)
+	}
+	return name
+}
+
+func randomItem(items []string) string {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(items))))
+	if err != nil {
+		return items[0]
+	}
+	return items[n.Int64()]
+}
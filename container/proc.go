@@ -0,0 +1,72 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// report whether a process with pid exists
+func ProcessExists(pid int) bool {
+	// signal 0 only checks that the process exists
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// start time of the process in clock ticks since boot, field 22 of /proc/<pid>/stat.
+// Together with the pid it identifies a process even when pids get reused.
+func ProcessStartTime(pid int) (uint64, error) {
+	fields, err := processStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// report whether the process has exited and waits to be reaped.
+// A zombie holds no mounts or namespaces any more.
+func ProcessZombie(pid int) (bool, error) {
+	fields, err := processStat(pid)
+	if err != nil {
+		return false, err
+	}
+	return fields[0] == "Z", nil
+}
+
+// fields of /proc/<pid>/stat from field 3, the state, on
+func processStat(pid int) ([]string, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// the command name in field 2 may contain spaces, skip past its closing paren
+	stat := string(content)
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return fields, nil
+}
+
+// report whether the process belongs to cgroupPath in any hierarchy
+func ProcessInCgroup(pid int, cgroupPath string) (bool, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return false, err
+	}
+	want := "/" + strings.Trim(cgroupPath, "/")
+	// lines look like hierarchy-id:controllers:/path
+	for _, line := range strings.Split(string(content), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) == 3 && (parts[2] == want || strings.HasSuffix(parts[2], want)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -1,7 +1,6 @@
 package container
 
 import (
-	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
 	"os/exec"
@@ -17,18 +16,57 @@ type ContainerInfo struct {
 	Status      string   `json:"status"`
 	Volume      string   `json:"volume"`
 	PortMapping []string `json:"portMapping"`
+	// ports of the image config and run --expose, like 80/tcp
+	ExposedPorts []string `json:"exposedPorts"`
+	// log driver and its --log-opt values
+	LogDriver string            `json:"logDriver"`
+	LogOpts   map[string]string `json:"logOpts"`
+	// image the rootfs comes from, as given to run
+	Image  string            `json:"image"`
+	Labels map[string]string `json:"labels"`
+	// exit status of the command, valid once exited, -1 when unknown
+	ExitCode int `json:"exitCode"`
+	// start time of Pid, to tell it apart from a later process reusing the pid
+	PidStartTime uint64 `json:"pidStartTime"`
+	// cgroup the process was put in
+	CgroupPath string `json:"cgroupPath"`
+	// layout version of the stored record
+	SchemaVersion int `json:"schemaVersion"`
+	// storage driver holding the write layer, kept until the container is removed
+	GraphDriver GraphDriverData `json:"graphDriver"`
+	// where the rootfs is mounted on the host, volumes are mounted below it
+	Rootfs string `json:"rootfs"`
+	// id of the image, it is kept while the container exists
+	ImageID string `json:"imageId"`
+}
+
+// what init runs in the container, handed over through the init pipe
+type ProcessConfig struct {
+	Args []string `json:"args"`
+	// KEY=value pairs, PATH and HOME get defaults when missing
+	Env []string `json:"env"`
+	// working directory, created when missing, / when empty
+	Dir string `json:"dir"`
+	// user[:group], names or ids, root when empty
+	User string `json:"user"`
+}
+
+// storage driver of a container and the directories of its write layer
+type GraphDriverData struct {
+	Name string            `json:"name"`
+	Data map[string]string `json:"data"`
 }
 
 var (
-	RUNNING             string = "running"
-	STOP                string = "stopped"
-	EXIT                string = "exited"
-	DefaultInfoLocation string = "/var/run/toy-docker/%s/"
-	ConfigName          string = "config.json"
-	ContainerLogFile    string = "container.log"
+	CREATED          string = "created"
+	RUNNING          string = "running"
+	STOP             string = "stopped"
+	EXIT             string = "exited"
+	ContainerLogFile string = "container.log"
 )
 
-func NewParentProcess(tty bool, containerName, volume string) (*exec.Cmd, *os.File) {
+// the init process runs in rootfs, the directory the container's workspace is mounted at
+func NewParentProcess(tty bool, rootfs string) (*exec.Cmd, *os.File) {
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
 		logrus.Errorf("New pipe error %v", err)
@@ -47,28 +85,15 @@ func NewParentProcess(tty bool, containerName, volume string) (*exec.Cmd, *os.Fi
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
-	} else {
-		// generate container.log corresponding to container
-		dirUrl := fmt.Sprintf(DefaultInfoLocation, containerName)
-		if err := os.MkdirAll(dirUrl, 0622); err != nil {
-			logrus.Errorf("NewPrarentProcess mkdir %s error %v", dirUrl, err)
-		}
-		stdLogFilePath := dirUrl + ContainerLogFile
-		stdLogFile, err := os.Create(stdLogFilePath)
-		if err != nil {
-			logrus.Errorf("NewParent Process Create file %s error %v", stdLogFile, err)
-		}
-		// generated file is assigned to stdout,
-		// so that the standard output in the processor can be redirected to this file
-		cmd.Stdout = stdLogFile
 	}
+	// without a tty stdout and stderr are wired to the log driver by the caller
 
 	// Here the handle of the pipe file reading end is passed in
 	cmd.ExtraFiles = []*os.File{
 		readPipe,
 	}
 
-	cmd.Dir = "/root/busybox"
+	cmd.Dir = rootfs
 	return cmd, writePipe
 }
 
@@ -0,0 +1,113 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// a user of the container as /etc/passwd and /etc/group describe it
+type execUser struct {
+	uid    int
+	gid    int
+	groups []int
+	home   string
+}
+
+// find user[:group], names or numeric ids, in the container's /etc/passwd and /etc/group.
+// Numeric ids need no entry there.
+func lookupUser(spec string) (*execUser, error) {
+	userSpec, groupSpec := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		userSpec, groupSpec = spec[:i], spec[i+1:]
+	}
+	user := &execUser{home: "/"}
+	name := ""
+	found := false
+	for _, fields := range readColonFile("/etc/passwd", 7) {
+		if fields[0] == userSpec || fields[2] == userSpec {
+			name = fields[0]
+			user.uid, _ = strconv.Atoi(fields[2])
+			user.gid, _ = strconv.Atoi(fields[3])
+			user.home = fields[5]
+			found = true
+			break
+		}
+	}
+	if !found {
+		uid, err := strconv.Atoi(userSpec)
+		if err != nil {
+			return nil, fmt.Errorf("no user %s in /etc/passwd", userSpec)
+		}
+		user.uid = uid
+	}
+
+	groups := readColonFile("/etc/group", 4)
+	if groupSpec != "" {
+		found = false
+		for _, fields := range groups {
+			if fields[0] == groupSpec || fields[2] == groupSpec {
+				user.gid, _ = strconv.Atoi(fields[2])
+				found = true
+				break
+			}
+		}
+		if !found {
+			gid, err := strconv.Atoi(groupSpec)
+			if err != nil {
+				return nil, fmt.Errorf("no group %s in /etc/group", groupSpec)
+			}
+			user.gid = gid
+		}
+	}
+	// supplementary groups listing the user by name
+	for _, fields := range groups {
+		if name == "" {
+			break
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if member == name {
+				if gid, err := strconv.Atoi(fields[2]); err == nil {
+					user.groups = append(user.groups, gid)
+				}
+			}
+		}
+	}
+	return user, nil
+}
+
+// drop to the user, groups first while we still may
+func (u *execUser) switchTo() error {
+	if err := syscall.Setgroups(u.groups); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(u.gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(u.uid)
+}
+
+// the lines of a colon separated file like /etc/passwd with at least n fields, a missing file has none
+func readColonFile(file string, n int) [][]string {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) >= n {
+			lines = append(lines, fields)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"ToyDocker/archive"
+	"ToyDocker/container"
+	"ToyDocker/fsutil"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// copy files between the host and a container, one of src and dst is CONTAINER:PATH.
+// - stands for a tar archive on stdin or stdout.
+func copyFiles(src, dst string) error {
+	srcContainer, srcPath := splitCopyArg(src)
+	dstContainer, dstPath := splitCopyArg(dst)
+	if srcContainer != "" && dstContainer != "" {
+		return fmt.Errorf("copying between containers is not supported")
+	}
+	if srcContainer == "" && dstContainer == "" {
+		return fmt.Errorf("one of source and destination must be CONTAINER:PATH")
+	}
+	if srcContainer != "" {
+		containerInfo, err := containerStore.Resolve(srcContainer)
+		if err != nil {
+			return err
+		}
+		return copyFromContainer(containerInfo, srcPath, dst)
+	}
+	containerInfo, err := containerStore.Resolve(dstContainer)
+	if err != nil {
+		return err
+	}
+	return copyToContainer(src, containerInfo, dstPath)
+}
+
+// split CONTAINER:PATH, a local path has no container.
+// Paths starting with / or . are local even with a colon in them.
+func splitCopyArg(arg string) (string, string) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
+		return "", arg
+	}
+	i := strings.Index(arg, ":")
+	if i <= 0 || strings.Contains(arg[:i], "/") {
+		return "", arg
+	}
+	return arg[:i], arg[i+1:]
+}
+
+func copyFromContainer(containerInfo *container.ContainerInfo, srcPath, dst string) error {
+	rootfs, release, err := mountContainer(containerInfo)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// a symlink is copied as it is, unless the path goes on into it
+	source, err := fsutil.ResolveInRoot(rootfs, srcPath, followsLast(srcPath))
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(source)
+	if err != nil {
+		return fmt.Errorf("no such file in container %s: %s", containerInfo.Name, srcPath)
+	}
+	if dst == "-" {
+		name := path.Base(path.Clean("/" + srcPath))
+		if name == "/" {
+			name = "."
+		}
+		return writeArchive(source, name)
+	}
+	dir, name, err := copyTarget(dst, dst, srcPath, info.IsDir())
+	if err != nil {
+		return err
+	}
+	return copyArchive(source, dir, name)
+}
+
+func copyToContainer(src string, containerInfo *container.ContainerInfo, dstPath string) error {
+	rootfs, release, err := mountContainer(containerInfo)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// the destination is followed through symlinks, but never out of the container
+	target, err := fsutil.ResolveInRoot(rootfs, dstPath, true)
+	if err != nil {
+		return err
+	}
+	if src == "-" {
+		if info, err := os.Stat(target); err != nil || !info.IsDir() {
+			return fmt.Errorf("destination %s must be a directory in container %s", dstPath, containerInfo.Name)
+		}
+		return archive.Untar(target, os.Stdin)
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	dir, name, err := copyTarget(dstPath, target, src, info.IsDir())
+	if err != nil {
+		return err
+	}
+	return copyArchive(src, dir, name)
+}
+
+// where a copy of src goes: the directory to unpack into and the name src gets there.
+// dst is the destination as given, target where it resolved to.
+// Like cp, a directory ending in /. has its contents copied rather than itself.
+func copyTarget(dst, target, src string, srcIsDir bool) (string, string, error) {
+	srcBase := path.Base(path.Clean("/" + src))
+	contentsOnly := srcIsDir && (strings.HasSuffix(src, "/.") || srcBase == "/")
+	info, err := os.Stat(target)
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", err
+	}
+	if err == nil && info.IsDir() {
+		if contentsOnly {
+			return target, ".", nil
+		}
+		return target, srcBase, nil
+	}
+	if err == nil && srcIsDir {
+		return "", "", fmt.Errorf("cannot copy directory %s onto file %s", src, dst)
+	}
+	if err != nil && strings.HasSuffix(dst, "/") && !srcIsDir {
+		return "", "", fmt.Errorf("destination directory %s does not exist", dst)
+	}
+	return filepath.Dir(target), filepath.Base(target), nil
+}
+
+// report whether the last element of name is followed when it is a symlink
+func followsLast(name string) bool {
+	return strings.HasSuffix(name, "/") || strings.HasSuffix(name, "/.")
+}
+
+// stream src through a tar archive into dir, named name there
+func copyArchive(src, dir, name string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("destination directory: %v", err)
+	}
+	reader, err := archive.TarPath(src, name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return archive.Untar(dir, reader)
+}
+
+// write src as a tar archive to stdout, named name in it
+func writeArchive(src, name string) error {
+	reader, err := archive.TarPath(src, name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(os.Stdout, reader)
+	return err
+}
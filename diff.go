@@ -0,0 +1,22 @@
+package main
+
+import (
+	"ToyDocker/container"
+	"fmt"
+)
+
+// print the files the container added (A), changed (C) and deleted (D) on top of its image
+func diffContainer(containerInfo *container.ContainerInfo) error {
+	driver, _, imageLayer, err := containerImageLayer(containerInfo)
+	if err != nil {
+		return err
+	}
+	changes, err := driver.Changes(containerInfo.Id, imageLayer.CacheID)
+	if err != nil {
+		return fmt.Errorf("changes of container %s: %v", containerInfo.Name, err)
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"ToyDocker/archive"
+	"ToyDocker/container"
+	"ToyDocker/image"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// write the merged rootfs of a container as a tar to output, stdout when empty.
+// The contents of its volume are left out, they are not part of the container.
+func exportContainer(containerInfo *container.ContainerInfo, output string) error {
+	rootfs, release, err := mountContainer(containerInfo)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	volume := ""
+	if volumeURLs := strings.Split(containerInfo.Volume, ":"); len(volumeURLs) == 2 && volumeURLs[1] != "" {
+		volume = strings.Trim(path.Clean("/"+volumeURLs[1]), "/")
+	}
+	include := func(name string) bool {
+		return volume == "" || !strings.HasPrefix(name, volume+"/")
+	}
+	// the merged view shows no whiteouts, it is exported as it is
+	reader, err := archive.TarFiltered(rootfs, archive.NoWhiteout, include)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	out := os.Stdout
+	if output != "" {
+		if out, err = os.Create(output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		return fmt.Errorf("export container %s: %v", containerInfo.Name, err)
+	}
+	return nil
+}
+
+// create a single layer image from the rootfs tar source, - for stdin, and tag it ref unless empty.
+// changes are Dockerfile instructions applied to the empty config.
+func importImage(source, ref, message string, changes []string) error {
+	in := os.Stdin
+	if source != "-" {
+		var err error
+		if in, err = os.Open(source); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	rootfs, err := maybeGunzip(in)
+	if err != nil {
+		return err
+	}
+	config := &image.Config{}
+	for _, change := range changes {
+		if err := image.ApplyChange(config, change); err != nil {
+			return err
+		}
+	}
+	comment := message
+	if comment == "" {
+		comment = "Imported from " + source
+	}
+	img, err := createImage(rootfs, ref, config, comment)
+	if err != nil {
+		return err
+	}
+	fmt.Println(img.ID)
+	return nil
+}
+
+// unpack the rootfs tar stream into a new layer and record it as image ref, untagged when empty
+func createImage(rootfs io.Reader, ref string, config *image.Config, comment string) (*image.Image, error) {
+	if ref != "" {
+		if _, err := image.ParseReference(ref); err != nil {
+			return nil, err
+		}
+	}
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return nil, err
+	}
+	l, err := layerStore.Register(rootfs, "")
+	if err != nil {
+		return nil, err
+	}
+	created := time.Now().UTC()
+	img := &image.Image{
+		Created:      created,
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		Comment:      comment,
+		Config:       config,
+		RootFS:       image.RootFS{Type: "layers", DiffIDs: []string{l.DiffID}},
+		History:      []image.History{{Created: created, Comment: comment}},
+	}
+	if err := storeImage(img, ref); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
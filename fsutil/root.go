@@ -0,0 +1,62 @@
+package fsutil
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// symlinks followed before giving up, like the kernel's limit
+const maxSymlinks = 40
+
+// ResolveInRoot returns where name, a path inside the directory tree root, really is.
+// Symlinks are followed as if root were /, so neither absolute targets nor .. lead out of it.
+// The last element is followed only with followLast. Missing elements are joined as they are.
+func ResolveInRoot(root, name string, followLast bool) (string, error) {
+	resolved := "/"
+	parts := strings.Split(name, "/")
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, part)
+		if len(parts) == 0 && !followLast {
+			resolved = next
+			break
+		}
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return "", err
+			}
+			resolved = next
+			continue
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", name)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+	return filepath.Join(root, resolved), nil
+}
@@ -0,0 +1,104 @@
+package graphdriver
+
+import (
+	"ToyDocker/archive"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// default data root, the drivers keep their layers in one subdirectory each
+const DefaultRoot = "/var/lib/toy-docker"
+
+// storage driver interface: a layer is a directory of files stacked on its parent.
+// Image layers are read-only, a container gets one read-write layer on top of its image.
+type Driver interface {
+	// return the name of the driver
+	String() string
+	// create a read-only layer on top of parent, "" for a base layer
+	Create(id, parent string) error
+	// create the writable layer of a container on top of parent
+	CreateReadWrite(id, parent string) error
+	// report whether layer id exists
+	Exists(id string) bool
+	// mount the layer together with its parents, return the directory holding the result
+	Get(id string) (string, error)
+	// release what Get mounted
+	Put(id string) error
+	// delete the layer, unmounting it first
+	Remove(id string) error
+	// tar stream of the changes layer id makes on top of parent
+	Diff(id, parent string) (io.ReadCloser, error)
+	// the paths layer id adds, changes and deletes on top of parent
+	Changes(id, parent string) ([]archive.Change, error)
+	// unpack a tar stream of changes into layer id, return the number of bytes read
+	ApplyDiff(id, parent string, diff io.Reader) (int64, error)
+	// directories backing layer id, recorded with a container for inspect
+	Metadata(id string) (map[string]string, error)
+	// key/value pairs describing the driver, for toy-docker info
+	Status() [][2]string
+	// release whatever the driver holds, called when done with it
+	Cleanup() error
+}
+
+type initFunc func(home string) (Driver, error)
+
+// all available storage drivers
+var drivers = map[string]initFunc{
+	"overlay": initOverlay,
+	"aufs":    initAufs,
+	"vfs":     initVfs,
+}
+
+// tried in this order when no driver is configured
+var priority = []string{"overlay", "aufs", "vfs"}
+
+// set up the driver called name with its layers in root/name.
+// An empty name picks the first driver that works on this host.
+func New(name, root string) (Driver, error) {
+	if name != "" {
+		initDriver, ok := drivers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown storage driver %s, available: %v", name, Names())
+		}
+		driver, err := initDriver(filepath.Join(root, name))
+		if err == ErrNotSupported {
+			return nil, fmt.Errorf("storage driver %s is not supported on this host", name)
+		}
+		return driver, err
+	}
+	for _, name := range priority {
+		driver, err := drivers[name](filepath.Join(root, name))
+		if err == nil {
+			return driver, nil
+		}
+		if err != ErrNotSupported {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no storage driver works on this host")
+}
+
+// names of all available storage drivers
+func Names() []string {
+	var names []string
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// returned by a driver's init when the host cannot run it
+var ErrNotSupported = fmt.Errorf("storage driver not supported")
+
+// number of layers under dir, for Status
+func countLayers(dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
@@ -0,0 +1,83 @@
+package graphdriver
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// report whether the kernel knows filesystem fs, according to /proc/filesystems
+func SupportedFilesystem(fs string) bool {
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// lines look like "nodev\toverlay" or "\text4"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == fs {
+			return true
+		}
+	}
+	return false
+}
+
+// report whether target is a mount point, according to /proc/self/mountinfo
+func Mounted(target string) bool {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	target = strings.TrimSuffix(target, "/")
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// the 5th field is the mount point
+		fields := strings.Split(scanner.Text(), " ")
+		if len(fields) > 4 && fields[4] == target {
+			return true
+		}
+	}
+	return false
+}
+
+// unmount target, detaching it lazily if it is still busy.
+// A target that is not mounted is not an error.
+func Unmount(target string) error {
+	err := syscall.Unmount(target, 0)
+	if err == syscall.EBUSY {
+		err = syscall.Unmount(target, syscall.MNT_DETACH)
+	}
+	// EINVAL: not a mount point
+	if err != nil && err != syscall.EINVAL && !os.IsNotExist(err) {
+		return fmt.Errorf("unmount %s: %v", target, err)
+	}
+	return nil
+}
+
+// name of the filesystem dir lives on, for Status
+func backingFilesystem(dir string) string {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return "unknown"
+	}
+	switch stat.Type {
+	case 0xEF53:
+		return "extfs"
+	case 0x58465342:
+		return "xfs"
+	case 0x9123683E:
+		return "btrfs"
+	case 0x01021994:
+		return "tmpfs"
+	case 0x794c7630:
+		return "overlayfs"
+	case 0x2FC12FC1:
+		return "zfs"
+	}
+	return fmt.Sprintf("0x%x", stat.Type)
+}
@@ -0,0 +1,216 @@
+package graphdriver
+
+import (
+	"ToyDocker/archive"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// union driver stacks layers with overlay, or aufs where the kernel still has it.
+// Every layer lives in <home>/layers/<id>:
+//
+//	diff/    the files of the layer
+//	work/    scratch space overlay needs next to diff
+//	merged/  where Get mounts the layer on top of its parents
+//	lower    ids of the parents, nearest first, separated by ':'
+type unionDriver struct {
+	home string
+	// filesystem to mount with, overlay or aufs
+	fs string
+}
+
+func initOverlay(home string) (Driver, error) {
+	return newUnionDriver(home, "overlay")
+}
+
+func initAufs(home string) (Driver, error) {
+	return newUnionDriver(home, "aufs")
+}
+
+func newUnionDriver(home, fs string) (Driver, error) {
+	if !SupportedFilesystem(fs) {
+		return nil, ErrNotSupported
+	}
+	if err := os.MkdirAll(filepath.Join(home, "layers"), 0700); err != nil {
+		return nil, err
+	}
+	return &unionDriver{home: home, fs: fs}, nil
+}
+
+func (d *unionDriver) String() string {
+	return d.fs
+}
+
+func (d *unionDriver) dir(id string) string {
+	return filepath.Join(d.home, "layers", id)
+}
+
+func (d *unionDriver) Exists(id string) bool {
+	_, err := os.Stat(d.dir(id))
+	return err == nil
+}
+
+func (d *unionDriver) Create(id, parent string) error {
+	return d.create(id, parent)
+}
+
+// container layers look the same as image layers, only Get writes to them
+func (d *unionDriver) CreateReadWrite(id, parent string) error {
+	return d.create(id, parent)
+}
+
+func (d *unionDriver) create(id, parent string) error {
+	if d.Exists(id) {
+		return fmt.Errorf("layer %s already exists", id)
+	}
+	var chain []string
+	if parent != "" {
+		if !d.Exists(parent) {
+			return fmt.Errorf("parent layer %s does not exist", parent)
+		}
+		lowers, err := d.lowers(parent)
+		if err != nil {
+			return err
+		}
+		chain = append([]string{parent}, lowers...)
+	}
+	dir := d.dir(id)
+	for _, sub := range []string{"diff", "work", "merged"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "lower"), []byte(strings.Join(chain, ":")), 0644); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+// ids of the parents of layer id, nearest first
+func (d *unionDriver) lowers(id string) ([]string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(d.dir(id), "lower"))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(content), ":"), nil
+}
+
+func (d *unionDriver) Get(id string) (string, error) {
+	lowers, err := d.lowers(id)
+	if err != nil {
+		return "", err
+	}
+	diff := filepath.Join(d.dir(id), "diff")
+	// a base layer needs no mount
+	if len(lowers) == 0 {
+		return diff, nil
+	}
+	merged := filepath.Join(d.dir(id), "merged")
+	if Mounted(merged) {
+		return merged, nil
+	}
+	var lowerDirs []string
+	for _, lower := range lowers {
+		lowerDirs = append(lowerDirs, filepath.Join(d.dir(lower), "diff"))
+	}
+	var data string
+	switch d.fs {
+	case "overlay":
+		data = fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+			strings.Join(lowerDirs, ":"), diff, filepath.Join(d.dir(id), "work"))
+	case "aufs":
+		branches := []string{diff + "=rw"}
+		for _, lower := range lowerDirs {
+			branches = append(branches, lower+"=ro+wh")
+		}
+		data = "br:" + strings.Join(branches, ":")
+	}
+	if len(data) >= syscall.Getpagesize() {
+		return "", fmt.Errorf("too many layers below %s to mount them", id)
+	}
+	if err := syscall.Mount(d.fs, merged, d.fs, 0, data); err != nil {
+		return "", fmt.Errorf("mount %s at %s: %v", d.fs, merged, err)
+	}
+	return merged, nil
+}
+
+func (d *unionDriver) Put(id string) error {
+	return Unmount(filepath.Join(d.dir(id), "merged"))
+}
+
+func (d *unionDriver) Remove(id string) error {
+	if err := d.Put(id); err != nil {
+		return err
+	}
+	return os.RemoveAll(d.dir(id))
+}
+
+// how the diff directories mark deleted files
+func (d *unionDriver) whiteoutFormat() archive.WhiteoutFormat {
+	if d.fs == "overlay" {
+		return archive.OverlayWhiteout
+	}
+	return archive.OCIWhiteout
+}
+
+// the diff directory already holds exactly the changes on top of the parents,
+// only the whiteouts need turning into OCI ones
+func (d *unionDriver) Diff(id, parent string) (io.ReadCloser, error) {
+	return archive.Tar(filepath.Join(d.dir(id), "diff"), d.whiteoutFormat())
+}
+
+// the diff directory is walked, the lower layers tell added files from changed ones
+func (d *unionDriver) Changes(id, parent string) ([]archive.Change, error) {
+	lowers, err := d.lowers(id)
+	if err != nil {
+		return nil, err
+	}
+	var lowerDirs []string
+	for _, lower := range lowers {
+		lowerDirs = append(lowerDirs, filepath.Join(d.dir(lower), "diff"))
+	}
+	return archive.ChangesLayer(filepath.Join(d.dir(id), "diff"), d.whiteoutFormat(), lowerDirs)
+}
+
+func (d *unionDriver) ApplyDiff(id, parent string, diff io.Reader) (int64, error) {
+	return archive.Apply(filepath.Join(d.dir(id), "diff"), diff, d.whiteoutFormat())
+}
+
+func (d *unionDriver) Metadata(id string) (map[string]string, error) {
+	lowers, err := d.lowers(id)
+	if err != nil {
+		return nil, err
+	}
+	var lowerDirs []string
+	for _, lower := range lowers {
+		lowerDirs = append(lowerDirs, filepath.Join(d.dir(lower), "diff"))
+	}
+	return map[string]string{
+		"LowerDir":  strings.Join(lowerDirs, ":"),
+		"UpperDir":  filepath.Join(d.dir(id), "diff"),
+		"WorkDir":   filepath.Join(d.dir(id), "work"),
+		"MergedDir": filepath.Join(d.dir(id), "merged"),
+	}, nil
+}
+
+func (d *unionDriver) Status() [][2]string {
+	return [][2]string{
+		{"Backing Filesystem", backingFilesystem(d.home)},
+		{"Layers", strconv.Itoa(countLayers(filepath.Join(d.home, "layers")))},
+	}
+}
+
+func (d *unionDriver) Cleanup() error {
+	return nil
+}
@@ -0,0 +1,121 @@
+package graphdriver
+
+import (
+	"ToyDocker/archive"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// vfs driver keeps every layer as a full copy of its parent plus its own changes.
+// Slow and big, but it needs no mount support and works on any filesystem,
+// which makes it handy for CI. Layers live in <home>/layers/<id>.
+type vfsDriver struct {
+	home string
+}
+
+func initVfs(home string) (Driver, error) {
+	if err := os.MkdirAll(filepath.Join(home, "layers"), 0700); err != nil {
+		return nil, err
+	}
+	return &vfsDriver{home: home}, nil
+}
+
+func (d *vfsDriver) String() string {
+	return "vfs"
+}
+
+func (d *vfsDriver) dir(id string) string {
+	return filepath.Join(d.home, "layers", id)
+}
+
+func (d *vfsDriver) Exists(id string) bool {
+	_, err := os.Stat(d.dir(id))
+	return err == nil
+}
+
+func (d *vfsDriver) Create(id, parent string) error {
+	if d.Exists(id) {
+		return fmt.Errorf("layer %s already exists", id)
+	}
+	if parent != "" && !d.Exists(parent) {
+		return fmt.Errorf("parent layer %s does not exist", parent)
+	}
+	if err := os.MkdirAll(d.dir(id), 0755); err != nil {
+		return err
+	}
+	if parent == "" {
+		return nil
+	}
+	// copy the parent, keeping owners, modes, links and device nodes
+	if output, err := exec.Command("cp", "-a", d.dir(parent)+"/.", d.dir(id)).CombinedOutput(); err != nil {
+		os.RemoveAll(d.dir(id))
+		return fmt.Errorf("copy layer %s: %v: %s", parent, err, output)
+	}
+	return nil
+}
+
+func (d *vfsDriver) CreateReadWrite(id, parent string) error {
+	return d.Create(id, parent)
+}
+
+func (d *vfsDriver) Get(id string) (string, error) {
+	if !d.Exists(id) {
+		return "", fmt.Errorf("layer %s does not exist", id)
+	}
+	return d.dir(id), nil
+}
+
+func (d *vfsDriver) Put(id string) error {
+	return nil
+}
+
+func (d *vfsDriver) Remove(id string) error {
+	return os.RemoveAll(d.dir(id))
+}
+
+// a vfs layer is a full copy, so its diff is found by comparing it with the parent
+func (d *vfsDriver) Diff(id, parent string) (io.ReadCloser, error) {
+	if parent == "" {
+		return archive.Tar(d.dir(id), archive.NoWhiteout)
+	}
+	changes, err := d.Changes(id, parent)
+	if err != nil {
+		return nil, err
+	}
+	return archive.ExportChanges(d.dir(id), changes)
+}
+
+func (d *vfsDriver) Changes(id, parent string) ([]archive.Change, error) {
+	parentDir := ""
+	if parent != "" {
+		parentDir = d.dir(parent)
+	}
+	return archive.ChangesDirs(d.dir(id), parentDir)
+}
+
+// whiteouts in the diff delete from the copy of the parent right away
+func (d *vfsDriver) ApplyDiff(id, parent string, diff io.Reader) (int64, error) {
+	return archive.Apply(d.dir(id), diff, archive.NoWhiteout)
+}
+
+func (d *vfsDriver) Metadata(id string) (map[string]string, error) {
+	if !d.Exists(id) {
+		return nil, fmt.Errorf("layer %s does not exist", id)
+	}
+	return map[string]string{"Dir": d.dir(id)}, nil
+}
+
+func (d *vfsDriver) Status() [][2]string {
+	return [][2]string{
+		{"Backing Filesystem", backingFilesystem(d.home)},
+		{"Layers", strconv.Itoa(countLayers(filepath.Join(d.home, "layers")))},
+	}
+}
+
+func (d *vfsDriver) Cleanup() error {
+	return nil
+}
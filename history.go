@@ -0,0 +1,160 @@
+package main
+
+import (
+	"ToyDocker/image"
+	"ToyDocker/layer"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// longest created by shown in history without -no-trunc
+const createdByLength = 45
+
+// print the steps that made an image, newest first, with the size of the layer each added
+func imageHistory(ref string, noTrunc bool) error {
+	img, err := resolveImage(ref)
+	if err != nil {
+		return err
+	}
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return err
+	}
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+
+	history := img.History
+	nonEmpty := 0
+	for _, entry := range history {
+		if !entry.EmptyLayer {
+			nonEmpty++
+		}
+	}
+	// images from elsewhere may not tell how their layers were made
+	ids := make(map[int]string)
+	if nonEmpty != len(img.RootFS.DiffIDs) {
+		history = nil
+		for range img.RootFS.DiffIDs {
+			history = append(history, image.History{Created: img.Created})
+		}
+		ids[len(history)-1] = img.ID
+	} else {
+		// the images of earlier steps, as far as they are still around
+		for parent := img; parent != nil && len(parent.History) > 0; {
+			ids[len(parent.History)-1] = parent.ID
+			if parent.Parent == "" {
+				break
+			}
+			if parent, err = imageStore.Get(parent.Parent); err != nil {
+				break
+			}
+		}
+	}
+
+	sizes := make([]int64, len(history))
+	chainID := ""
+	diffIndex := 0
+	for i, entry := range history {
+		if entry.EmptyLayer || diffIndex >= len(img.RootFS.DiffIDs) {
+			continue
+		}
+		chainID = layer.ChainID(chainID, img.RootFS.DiffIDs[diffIndex])
+		diffIndex++
+		if l, err := layerStore.Get(chainID); err == nil {
+			sizes[i] = l.Size
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "IMAGE\tCREATED\tCREATED BY\tSIZE\tCOMMENT\n")
+	for i := len(history) - 1; i >= 0; i-- {
+		id := "<missing>"
+		if ids[i] != "" {
+			id = imageID(ids[i], noTrunc)
+		}
+		createdBy := strings.Join(strings.Fields(history[i].CreatedBy), " ")
+		if !noTrunc && len(createdBy) > createdByLength {
+			createdBy = createdBy[:createdByLength-3] + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			id,
+			history[i].Created.Local().Format("2006-01-02 15:04:05"),
+			createdBy,
+			humanSize(sizes[i]),
+			history[i].Comment)
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("Flush error %v", err)
+	}
+	return nil
+}
+
+// what image inspect prints about an image
+type imageDetails struct {
+	Id           string
+	RepoTags     []string
+	Parent       string
+	Comment      string
+	Created      time.Time
+	Author       string
+	Architecture string
+	Os           string
+	Config       *image.Config
+	RootFS       imageDetailsRootFS
+	// bytes of all layer diffs
+	Size int64
+}
+
+type imageDetailsRootFS struct {
+	Type string
+	// diff ids, bottom up
+	Layers []string
+}
+
+// print the config, layers and size of images as a json array
+func inspectImages(refs []string) error {
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+	var details []imageDetails
+	for _, ref := range refs {
+		img, err := resolveImage(ref)
+		if err != nil {
+			return err
+		}
+		tags, err := imageStore.Tags(img.ID)
+		if err != nil {
+			return err
+		}
+		details = append(details, imageDetails{
+			Id:           img.ID,
+			RepoTags:     tags,
+			Parent:       img.Parent,
+			Comment:      img.Comment,
+			Created:      img.Created,
+			Author:       img.Author,
+			Architecture: img.Architecture,
+			Os:           img.OS,
+			Config:       img.Config.Copy(),
+			RootFS: imageDetailsRootFS{
+				Type:   img.RootFS.Type,
+				Layers: img.RootFS.DiffIDs,
+			},
+			Size: imageSize(img),
+		})
+	}
+	content, err := json.MarshalIndent(details, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
@@ -0,0 +1,81 @@
+package image
+
+import (
+	"ToyDocker/fsutil"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// the build cache maps the digest of a build step and everything before it
+// to the image the step produced, in <root>/build-cache.json
+
+func (s *Store) buildCachePath() string {
+	return filepath.Join(s.root, "build-cache.json")
+}
+
+func (s *Store) buildCache() (map[string]string, error) {
+	cache := make(map[string]string)
+	content, err := ioutil.ReadFile(s.buildCachePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &cache); err != nil {
+		return nil, fmt.Errorf("decode %s: %v", s.buildCachePath(), err)
+	}
+	return cache, nil
+}
+
+// the image a build step with cache key key produced before, nil when there is none
+// or the image is gone since
+func (s *Store) CachedImage(key string) (*Image, error) {
+	lock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+	cache, err := s.buildCache()
+	if err != nil {
+		return nil, err
+	}
+	id, ok := cache[key]
+	if !ok {
+		return nil, nil
+	}
+	img, err := s.get(id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return img, err
+}
+
+// remember that the build step with cache key key produced image id
+func (s *Store) CacheImage(key, id string) error {
+	lock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer fsutil.UnlockFile(lock)
+	cache, err := s.buildCache()
+	if err != nil {
+		return err
+	}
+	// entries of deleted images go
+	for cachedKey, cachedID := range cache {
+		if _, err := os.Stat(s.imagePath(cachedID)); os.IsNotExist(err) {
+			delete(cache, cachedKey)
+		}
+	}
+	cache[key] = id
+	content, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(s.buildCachePath(), content, 0600)
+}
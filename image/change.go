@@ -0,0 +1,149 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// instructions commit -c accepts
+var changeInstructions = []string{"CMD", "ENTRYPOINT", "ENV", "EXPOSE", "LABEL", "USER", "WORKDIR"}
+
+// apply a Dockerfile style instruction like `ENV PATH=/bin` or `CMD ["sh"]` to config
+func ApplyChange(config *Config, change string) error {
+	fields := strings.SplitN(strings.TrimSpace(change), " ", 2)
+	instruction := strings.ToUpper(fields[0])
+	args := ""
+	if len(fields) == 2 {
+		args = strings.TrimSpace(fields[1])
+	}
+	if args == "" {
+		return fmt.Errorf("%s needs an argument", instruction)
+	}
+	switch instruction {
+	case "CMD":
+		config.Cmd = ParseCommand(args)
+	case "ENTRYPOINT":
+		config.Entrypoint = ParseCommand(args)
+	case "ENV":
+		pairs, err := parsePairs(args)
+		if err != nil {
+			return fmt.Errorf("ENV: %v", err)
+		}
+		for _, pair := range pairs {
+			config.Env = SetEnv(config.Env, pair[0], pair[1])
+		}
+	case "LABEL":
+		pairs, err := parsePairs(args)
+		if err != nil {
+			return fmt.Errorf("LABEL: %v", err)
+		}
+		if config.Labels == nil {
+			config.Labels = make(map[string]string)
+		}
+		for _, pair := range pairs {
+			config.Labels[pair[0]] = pair[1]
+		}
+	case "EXPOSE":
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = make(map[string]struct{})
+		}
+		for _, port := range strings.Fields(args) {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			if _, err := strconv.Atoi(strings.SplitN(port, "/", 2)[0]); err != nil {
+				return fmt.Errorf("EXPOSE: invalid port %s", port)
+			}
+			config.ExposedPorts[port] = struct{}{}
+		}
+	case "USER":
+		config.User = args
+	case "WORKDIR":
+		config.WorkingDir = args
+	default:
+		return fmt.Errorf("unknown instruction %s, available: %s", instruction, strings.Join(changeInstructions, ", "))
+	}
+	return nil
+}
+
+// a JSON array is taken as is, anything else is run by /bin/sh -c
+func ParseCommand(args string) []string {
+	var argv []string
+	if strings.HasPrefix(args, "[") && json.Unmarshal([]byte(args), &argv) == nil {
+		return argv
+	}
+	return []string{"/bin/sh", "-c", args}
+}
+
+// set key to value in a list of key=value pairs
+func SetEnv(env []string, key, value string) []string {
+	for i, pair := range env {
+		if strings.SplitN(pair, "=", 2)[0] == key {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
+
+// parse `key=value key2="some value"`, or the older `key some value`
+func parsePairs(args string) ([][2]string, error) {
+	words, err := splitWords(args)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(words[0], "=") {
+		fields := strings.SplitN(args, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s has no value", args)
+		}
+		return [][2]string{{fields[0], strings.TrimSpace(fields[1])}}, nil
+	}
+	var pairs [][2]string
+	for _, word := range words {
+		kv := strings.SplitN(word, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("%s is not in key=value format", word)
+		}
+		pairs = append(pairs, [2]string{kv[0], kv[1]})
+	}
+	return pairs, nil
+}
+
+// split on spaces outside double quotes, dropping the quotes
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted, escaped := false, false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case r == ' ' && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %s", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,115 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyChange(t *testing.T) {
+	tests := []struct {
+		change string
+		want   Config
+	}{
+		{`CMD ["sh", "-c", "echo hi"]`, Config{Cmd: []string{"sh", "-c", "echo hi"}}},
+		{`CMD echo hi`, Config{Cmd: []string{"/bin/sh", "-c", "echo hi"}}},
+		{`entrypoint ["/entry"]`, Config{Entrypoint: []string{"/entry"}}},
+		{`ENV PATH=/bin HOME="/home/some one"`, Config{Env: []string{"PATH=/bin", "HOME=/home/some one"}}},
+		{`ENV GREETING hello world`, Config{Env: []string{"GREETING=hello world"}}},
+		{`LABEL team=infra version=1`, Config{Labels: map[string]string{"team": "infra", "version": "1"}}},
+		{`EXPOSE 80 53/udp`, Config{ExposedPorts: map[string]struct{}{"80/tcp": {}, "53/udp": {}}}},
+		{`USER 1000:1000`, Config{User: "1000:1000"}},
+		{`WORKDIR /app`, Config{WorkingDir: "/app"}},
+	}
+	for _, test := range tests {
+		config := &Config{}
+		if err := ApplyChange(config, test.change); err != nil {
+			t.Errorf("%s: %v", test.change, err)
+			continue
+		}
+		if !reflect.DeepEqual(*config, test.want) {
+			t.Errorf("%s gives %+v, want %+v", test.change, *config, test.want)
+		}
+	}
+}
+
+// ENV replaces a variable already set, LABEL adds to the labels there are
+func TestApplyChangeMerges(t *testing.T) {
+	config := &Config{
+		Env:    []string{"PATH=/usr/bin", "TERM=xterm"},
+		Labels: map[string]string{"team": "infra"},
+	}
+	for _, change := range []string{"ENV PATH=/bin", "LABEL version=2"} {
+		if err := ApplyChange(config, change); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"PATH=/bin", "TERM=xterm"}; !reflect.DeepEqual(config.Env, want) {
+		t.Errorf("env %v, want %v", config.Env, want)
+	}
+	if want := map[string]string{"team": "infra", "version": "2"}; !reflect.DeepEqual(config.Labels, want) {
+		t.Errorf("labels %v, want %v", config.Labels, want)
+	}
+}
+
+func TestApplyChangeErrors(t *testing.T) {
+	for _, change := range []string{
+		"CMD",
+		"RUN echo hi",
+		"ENV A",
+		`ENV A="unterminated`,
+		"LABEL =x",
+		"EXPOSE http",
+	} {
+		if err := ApplyChange(&Config{}, change); err == nil {
+			t.Errorf("%s applied", change)
+		}
+	}
+}
+
+func TestConfigCopy(t *testing.T) {
+	config := &Config{
+		Env:          []string{"A=1"},
+		Cmd:          []string{"sh"},
+		Labels:       map[string]string{"a": "1"},
+		ExposedPorts: map[string]struct{}{"80/tcp": {}},
+	}
+	copied := config.Copy()
+	copied.Env[0] = "A=2"
+	copied.Cmd[0] = "bash"
+	copied.Labels["a"] = "2"
+	copied.ExposedPorts["443/tcp"] = struct{}{}
+	if config.Env[0] != "A=1" || config.Cmd[0] != "sh" || config.Labels["a"] != "1" || len(config.ExposedPorts) != 1 {
+		t.Errorf("changing the copy changed the original: %+v", config)
+	}
+	if (*Config)(nil).Copy() == nil {
+		t.Error("copy of nil is nil")
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    string
+		invalid bool
+	}{
+		{ref: "busybox", want: "busybox:latest"},
+		{ref: "busybox:1.36", want: "busybox:1.36"},
+		{ref: "localhost:5000/team/app", want: "localhost:5000/team/app:latest"},
+		{ref: "registry.example.com:5000/app:v1", want: "registry.example.com:5000/app:v1"},
+		{ref: "Busybox", invalid: true},
+		{ref: "app:", invalid: true},
+		{ref: "app:-bad", invalid: true},
+	}
+	for _, test := range tests {
+		got, err := ParseReference(test.ref)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s parsed to %s", test.ref, got)
+			}
+			continue
+		}
+		if err != nil || got.String() != test.want {
+			t.Errorf("%s parsed to %s %v, want %s", test.ref, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"time"
+)
+
+// length of the ids images are shown with
+const ShortIDLength = 12
+
+// Image is a read-only rootfs containers are created from.
+// The record is an OCI image config, its digest is the image id.
+type Image struct {
+	// sha256 digest of the rest of the record, set by the store
+	ID      string    `json:"-"`
+	Created time.Time `json:"created"`
+	// image this one was committed from
+	Parent       string `json:"parent,omitempty"`
+	Author       string `json:"author,omitempty"`
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Comment      string `json:"comment,omitempty"`
+	// defaults for the containers created from the image
+	Config  *Config   `json:"config,omitempty"`
+	RootFS  RootFS    `json:"rootfs"`
+	History []History `json:"history,omitempty"`
+}
+
+// Config holds what containers of an image run with unless told otherwise
+type Config struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+}
+
+// History describes how one step of the image came about, bottom up
+type History struct {
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	// the step changed only the config, it has no layer
+	EmptyLayer bool `json:"empty_layer,omitempty"`
+}
+
+// deep copy of c, an empty config when c is nil
+func (c *Config) Copy() *Config {
+	copied := &Config{}
+	if c == nil {
+		return copied
+	}
+	*copied = *c
+	copied.Env = append([]string(nil), c.Env...)
+	copied.Entrypoint = append([]string(nil), c.Entrypoint...)
+	copied.Cmd = append([]string(nil), c.Cmd...)
+	if c.ExposedPorts != nil {
+		copied.ExposedPorts = make(map[string]struct{})
+		for port := range c.ExposedPorts {
+			copied.ExposedPorts[port] = struct{}{}
+		}
+	}
+	if c.Labels != nil {
+		copied.Labels = make(map[string]string)
+		for key, value := range c.Labels {
+			copied.Labels[key] = value
+		}
+	}
+	return copied
+}
+
+// RootFS lists the layers of an image, bottom up
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// sha256 digest of content, the way images and blobs are addressed
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// sha256 digest of everything r yields
+func DigestReader(r io.Reader) (string, error) {
+	digester := sha256.New()
+	if _, err := io.Copy(digester, r); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(digester.Sum(nil)), nil
+}
+
+// the hex part of an image id, cut to ShortIDLength
+func ShortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > ShortIDLength {
+		return id[:ShortIDLength]
+	}
+	return id
+}
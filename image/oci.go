@@ -0,0 +1,70 @@
+package image
+
+// media types of the OCI image spec, and the docker ones registries still serve
+const (
+	MediaTypeLayoutIndex = "application/vnd.oci.image.index.v1+json"
+	MediaTypeManifest    = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeConfig      = "application/vnd.oci.image.config.v1+json"
+	MediaTypeLayer       = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeLayerGzip   = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar"
+	MediaTypeDockerLayerGzip    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// annotations naming an image in an index
+const (
+	// the tag, in OCI layouts
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+	// the full name:tag, as containerd and docker write it
+	AnnotationImageName = "io.containerd.image.name"
+)
+
+// Descriptor points at a blob by digest
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// Manifest lists the config and layers of one image
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Index lists manifests, in index.json of a layout or for several platforms
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// the oci-layout file at the root of a layout
+type Layout struct {
+	Version string `json:"imageLayoutVersion"`
+}
+
+// one image in the manifest.json of docker save
+type LegacyManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// report whether layers of mediaType are gzip compressed
+func Gzipped(mediaType string) bool {
+	return mediaType == MediaTypeLayerGzip || mediaType == MediaTypeDockerLayerGzip
+}
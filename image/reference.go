@@ -0,0 +1,42 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tag used when a reference names none
+const DefaultTag = "latest"
+
+var (
+	// path components are lowercase, the first may be a registry host with a port
+	namePattern = regexp.MustCompile(`^([a-zA-Z0-9.-]+(:[0-9]+)?/)?[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+	tagPattern  = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+)
+
+// Reference names an image by repository name and tag, like busybox:latest
+type Reference struct {
+	Name string
+	Tag  string
+}
+
+func (r Reference) String() string {
+	return r.Name + ":" + r.Tag
+}
+
+// parse NAME[:TAG], the tag defaults to latest
+func ParseReference(ref string) (Reference, error) {
+	name, tag := ref, DefaultTag
+	// a colon after the last slash separates the tag, one before it is a registry port
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, tag = ref[:i], ref[i+1:]
+	}
+	if !namePattern.MatchString(name) {
+		return Reference{}, fmt.Errorf("invalid image name %q", name)
+	}
+	if !tagPattern.MatchString(tag) {
+		return Reference{}, fmt.Errorf("invalid image tag %q", tag)
+	}
+	return Reference{Name: name, Tag: tag}, nil
+}
@@ -0,0 +1,302 @@
+package image
+
+import (
+	"ToyDocker/fsutil"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// returned, wrapped, when a reference matches no image
+var ErrNotFound = errors.New("no such image")
+
+// Store keeps image records in <root>/images/<hex>.json and the
+// name:tag references in <root>/repositories.json.
+// A flock on <root>/lock guards both.
+type Store struct {
+	root string
+}
+
+func NewStore(root string) *Store {
+	return &Store{root: root}
+}
+
+func (s *Store) imagePath(id string) string {
+	return filepath.Join(s.root, "images", strings.TrimPrefix(id, "sha256:")+".json")
+}
+
+func (s *Store) repositoriesPath() string {
+	return filepath.Join(s.root, "repositories.json")
+}
+
+func (s *Store) lock(exclusive bool) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Join(s.root, "images"), 0700); err != nil {
+		return nil, err
+	}
+	return fsutil.LockFile(filepath.Join(s.root, "lock"), exclusive)
+}
+
+// record img and set its id, report whether it is new.
+// The same content always gives the same image.
+func (s *Store) Create(img *Image) (bool, error) {
+	content, err := json.Marshal(img)
+	if err != nil {
+		return false, err
+	}
+	created, err := s.create(content)
+	img.ID = Digest(content)
+	return created, err
+}
+
+// record an image from its config as read elsewhere, e.g. from a saved image.
+// The bytes are kept as they are so the id stays the same.
+func (s *Store) CreateFromConfig(content []byte) (*Image, bool, error) {
+	var img Image
+	if err := json.Unmarshal(content, &img); err != nil {
+		return nil, false, fmt.Errorf("decode image config: %v", err)
+	}
+	created, err := s.create(content)
+	img.ID = Digest(content)
+	return &img, created, err
+}
+
+func (s *Store) create(content []byte) (bool, error) {
+	id := Digest(content)
+	lock, err := s.lock(true)
+	if err != nil {
+		return false, err
+	}
+	defer fsutil.UnlockFile(lock)
+	if _, err := os.Stat(s.imagePath(id)); err == nil {
+		return false, nil
+	}
+	if err := fsutil.WriteFileAtomic(s.imagePath(id), content, 0600); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// the config of the image with full id, as stored
+func (s *Store) Config(id string) ([]byte, error) {
+	lock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+	return ioutil.ReadFile(s.imagePath(id))
+}
+
+func (s *Store) get(id string) (*Image, error) {
+	content, err := ioutil.ReadFile(s.imagePath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return nil, err
+	}
+	var img Image
+	if err := json.Unmarshal(content, &img); err != nil {
+		return nil, fmt.Errorf("decode image %s: %v", id, err)
+	}
+	img.ID = "sha256:" + strings.TrimPrefix(id, "sha256:")
+	return &img, nil
+}
+
+// read the image with full id
+func (s *Store) Get(id string) (*Image, error) {
+	lock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+	return s.get(id)
+}
+
+func (s *Store) list() ([]*Image, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.root, "images"))
+	if err != nil {
+		return nil, err
+	}
+	var images []*Image
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		img, err := s.get(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// read all images
+func (s *Store) List() ([]*Image, error) {
+	lock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+	return s.list()
+}
+
+// remove the image with full id and every reference to it
+func (s *Store) Delete(id string) error {
+	lock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer fsutil.UnlockFile(lock)
+	refs, err := s.references()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for ref, target := range refs {
+		if target == id {
+			delete(refs, ref)
+			changed = true
+		}
+	}
+	if changed {
+		if err := s.writeReferences(refs); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(s.imagePath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) references() (map[string]string, error) {
+	refs := make(map[string]string)
+	content, err := ioutil.ReadFile(s.repositoriesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return refs, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &refs); err != nil {
+		return nil, fmt.Errorf("decode %s: %v", s.repositoriesPath(), err)
+	}
+	return refs, nil
+}
+
+func (s *Store) writeReferences(refs map[string]string) error {
+	content, err := json.MarshalIndent(refs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(s.repositoriesPath(), content, 0600)
+}
+
+// all name:tag references and the image ids they point to
+func (s *Store) References() (map[string]string, error) {
+	lock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+	return s.references()
+}
+
+// references to image id, sorted
+func (s *Store) Tags(id string) ([]string, error) {
+	refs, err := s.References()
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for ref, target := range refs {
+		if target == id {
+			tags = append(tags, ref)
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// point ref at image id, moving it away from any image it named before
+func (s *Store) Tag(ref Reference, id string) error {
+	lock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer fsutil.UnlockFile(lock)
+	if _, err := s.get(id); err != nil {
+		return err
+	}
+	refs, err := s.references()
+	if err != nil {
+		return err
+	}
+	refs[ref.String()] = id
+	return s.writeReferences(refs)
+}
+
+// remove ref, return the id of the image it pointed to
+func (s *Store) Untag(ref Reference) (string, error) {
+	lock, err := s.lock(true)
+	if err != nil {
+		return "", err
+	}
+	defer fsutil.UnlockFile(lock)
+	refs, err := s.references()
+	if err != nil {
+		return "", err
+	}
+	id, ok := refs[ref.String()]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	delete(refs, ref.String())
+	return id, s.writeReferences(refs)
+}
+
+// find the image a command argument refers to:
+// a name[:tag], its full id or a prefix of exactly one id
+func (s *Store) Resolve(ref string) (*Image, error) {
+	lock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+	if parsed, err := ParseReference(ref); err == nil {
+		refs, err := s.references()
+		if err != nil {
+			return nil, err
+		}
+		if id, ok := refs[parsed.String()]; ok {
+			return s.get(id)
+		}
+	}
+	prefix := strings.TrimPrefix(ref, "sha256:")
+	if prefix == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	images, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	var found *Image
+	for _, img := range images {
+		if strings.HasPrefix(strings.TrimPrefix(img.ID, "sha256:"), prefix) {
+			if found != nil {
+				return nil, fmt.Errorf("image id prefix %s is ambiguous", ref)
+			}
+			found = img
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	return found, nil
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"ToyDocker/container"
+	"ToyDocker/image"
+	"ToyDocker/layer"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// find the image ref names
+func resolveImage(ref string) (*image.Image, error) {
+	imageStore, err := getImageStore()
+	if err != nil {
+		return nil, err
+	}
+	img, err := imageStore.Resolve(ref)
+	if errors.Is(err, image.ErrNotFound) {
+		return nil, fmt.Errorf("%w, pull, load or import it first", err)
+	}
+	return img, err
+}
+
+// record img and tag it ref, unless ref is empty.
+// The caller holds a reference on the top layer of img, the image takes it over.
+func storeImage(img *image.Image, ref string) error {
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return err
+	}
+	created, err := imageStore.Create(img)
+	if err != nil || !created {
+		// an image with the same content already holds the layers
+		if releaseErr := layerStore.Release(layer.ChainIDs(img.RootFS.DiffIDs)); releaseErr != nil {
+			logrus.Errorf("Release layer error %v", releaseErr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if ref == "" {
+		return nil
+	}
+	parsed, err := image.ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	return imageStore.Tag(parsed, img.ID)
+}
+
+// register the layers with diffIDs, bottom up, reading the diffs of the ones
+// not stored yet from open. Return the chain id of the top layer, the caller
+// holds a reference on it.
+func registerLayers(diffIDs []string, open func(i int) (io.ReadCloser, error)) (string, error) {
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return "", err
+	}
+	// the reference held on the topmost layer so far
+	held := ""
+	release := func() {
+		if held != "" {
+			if err := layerStore.Release(held); err != nil {
+				logrus.Errorf("Release layer error %v", err)
+			}
+		}
+	}
+	for i, diffID := range diffIDs {
+		// a layer stored before is not read again
+		if l, err := layerStore.Acquire(layer.ChainID(held, diffID)); err == nil {
+			release()
+			held = l.ChainID
+			continue
+		}
+		diff, err := open(i)
+		if err != nil {
+			release()
+			return "", err
+		}
+		l, err := layerStore.Register(diff, held)
+		// closing checks what was read, for diffs coming with a digest
+		closeErr := diff.Close()
+		if err != nil {
+			release()
+			return "", err
+		}
+		// the new layer holds its parent now
+		release()
+		held = l.ChainID
+		if closeErr == nil && l.DiffID != diffID {
+			closeErr = fmt.Errorf("layer %d has diff id %s, the config says %s", i, l.DiffID, diffID)
+		}
+		if closeErr != nil {
+			release()
+			return "", closeErr
+		}
+	}
+	return held, nil
+}
+
+// record the image with config content, whose top layer the caller holds a reference on,
+// tag it and tell so, starting with verb
+func storeImageConfig(content []byte, topChainID string, tags []string, verb string) error {
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return err
+	}
+	img, created, err := imageStore.CreateFromConfig(content)
+	if err != nil || !created {
+		// an image with the same config already holds the layers
+		if releaseErr := layerStore.Release(topChainID); releaseErr != nil {
+			logrus.Errorf("Release layer error %v", releaseErr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(tags) == 0 {
+		fmt.Printf("%s ID: %s\n", verb, img.ID)
+	}
+	for _, tag := range tags {
+		parsed, err := image.ParseReference(tag)
+		if err != nil {
+			return err
+		}
+		if err := imageStore.Tag(parsed, img.ID); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", verb, parsed)
+	}
+	return nil
+}
+
+// the topmost layer of img, the one containers are stacked on
+func topLayer(img *image.Image) (*layer.Layer, error) {
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return nil, err
+	}
+	if len(img.RootFS.DiffIDs) == 0 {
+		return nil, fmt.Errorf("image %s has no layers", image.ShortID(img.ID))
+	}
+	return layerStore.Get(layer.ChainIDs(img.RootFS.DiffIDs))
+}
+
+// intermediate images, untagged parents of other images, are left out unless all is set
+func listImages(quiet, noTrunc, all bool) error {
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+	images, err := imageStore.List()
+	if err != nil {
+		return err
+	}
+	refs, err := imageStore.References()
+	if err != nil {
+		return err
+	}
+	// newest first
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].Created.After(images[j].Created)
+	})
+	tags := make(map[string][]image.Reference)
+	for ref, id := range refs {
+		parsed, err := image.ParseReference(ref)
+		if err != nil {
+			continue
+		}
+		tags[id] = append(tags[id], parsed)
+	}
+	if !all {
+		parents := make(map[string]bool)
+		for _, img := range images {
+			parents[img.Parent] = true
+		}
+		listed := images[:0]
+		for _, img := range images {
+			if len(tags[img.ID]) > 0 || !parents[img.ID] {
+				listed = append(listed, img)
+			}
+		}
+		images = listed
+	}
+
+	if quiet {
+		for _, img := range images {
+			fmt.Println(imageID(img.ID, noTrunc))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, img := range images {
+		imageTags := tags[img.ID]
+		sort.Slice(imageTags, func(i, j int) bool {
+			return imageTags[i].String() < imageTags[j].String()
+		})
+		// untagged images are listed once as <none>
+		if len(imageTags) == 0 {
+			imageTags = []image.Reference{{Name: "<none>", Tag: "<none>"}}
+		}
+		for _, ref := range imageTags {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				ref.Name,
+				ref.Tag,
+				imageID(img.ID, noTrunc),
+				img.Created.Local().Format("2006-01-02 15:04:05"),
+				humanSize(imageSize(img)))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("Flush error %v", err)
+	}
+	return nil
+}
+
+// bytes of all layer diffs of img
+func imageSize(img *image.Image) int64 {
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return 0
+	}
+	var size int64
+	chainID := ""
+	for _, diffID := range img.RootFS.DiffIDs {
+		chainID = layer.ChainID(chainID, diffID)
+		if l, err := layerStore.Get(chainID); err == nil {
+			size += l.Size
+		}
+	}
+	return size
+}
+
+func imageID(id string, noTrunc bool) string {
+	if noTrunc {
+		return id
+	}
+	return image.ShortID(id)
+}
+
+// size in the largest unit that keeps it above 1, like 4.2MB
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", size, units[0])
+	}
+	return fmt.Sprintf("%.3g%s", value, units[unit])
+}
+
+// point dst at the image src refers to
+func tagImage(src, dst string) error {
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+	img, err := resolveImage(src)
+	if err != nil {
+		return err
+	}
+	ref, err := image.ParseReference(dst)
+	if err != nil {
+		return err
+	}
+	return imageStore.Tag(ref, img.ID)
+}
+
+func removeImages(refs []string, force bool) error {
+	failed := 0
+	for _, ref := range refs {
+		if err := removeImage(ref, force); err != nil {
+			logrus.Errorf("Remove image %s error %v", ref, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d images", failed)
+	}
+	return nil
+}
+
+// remove one tag of an image, or the image itself once it has no other tags.
+// Images containers are created from or other images are built on stay,
+// the latter are only untagged when removed by their last tag. force only
+// lets an image with several tags go by its id, it overrides nothing else.
+func removeImage(ref string, force bool) error {
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+	img, err := imageStore.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	tags, err := imageStore.Tags(img.ID)
+	if err != nil {
+		return err
+	}
+	byTag := false
+	if parsed, err := image.ParseReference(ref); err == nil {
+		for _, tag := range tags {
+			if tag == parsed.String() {
+				byTag = true
+			}
+		}
+		// other tags keep the image alive
+		if byTag && len(tags) > 1 {
+			if _, err := imageStore.Untag(parsed); err != nil {
+				return err
+			}
+			fmt.Printf("Untagged: %s\n", parsed)
+			return nil
+		}
+	}
+	if !byTag && len(tags) > 1 && !force {
+		return fmt.Errorf("image %s is tagged %v, remove the tags or use rmi -f", image.ShortID(img.ID), tags)
+	}
+
+	// the write layers of the containers sit on the image layer, even -f
+	// would only leave an untagged image nothing removes later
+	containers, err := containerStore.List()
+	if err != nil {
+		return fmt.Errorf("List containers error %v", err)
+	}
+	for _, item := range containers {
+		if item.ImageID != img.ID {
+			continue
+		}
+		if containerRunning(item.Id) {
+			return fmt.Errorf("image is used by running container %s", item.Name)
+		}
+		return fmt.Errorf("image is used by container %s, remove it first", item.Name)
+	}
+
+	images, err := imageStore.List()
+	if err != nil {
+		return err
+	}
+	hasChildren := false
+	for _, other := range images {
+		if other.Parent == img.ID {
+			hasChildren = true
+		}
+	}
+	if hasChildren && !byTag {
+		return fmt.Errorf("image %s has dependent child images", image.ShortID(img.ID))
+	}
+
+	for _, tag := range tags {
+		parsed, err := image.ParseReference(tag)
+		if err != nil {
+			continue
+		}
+		if _, err := imageStore.Untag(parsed); err != nil {
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", tag)
+	}
+	// children keep it as an intermediate image
+	if hasChildren {
+		return nil
+	}
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return err
+	}
+	// untagged parents nothing else uses go along, as with docker rmi
+	for img != nil {
+		if err := imageStore.Delete(img.ID); err != nil {
+			return err
+		}
+		// layers other images share stay
+		if err := layerStore.Release(layer.ChainIDs(img.RootFS.DiffIDs)); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted: %s\n", img.ID)
+		if img, err = unusedParent(imageStore, img.Parent, containers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the parent image if it has no tags, children or containers, nil otherwise
+func unusedParent(imageStore *image.Store, id string, containers []*container.ContainerInfo) (*image.Image, error) {
+	if id == "" {
+		return nil, nil
+	}
+	parent, err := imageStore.Get(id)
+	if err != nil {
+		if errors.Is(err, image.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if tags, err := imageStore.Tags(id); err != nil || len(tags) > 0 {
+		return nil, err
+	}
+	images, err := imageStore.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range images {
+		if other.Parent == id {
+			return nil, nil
+		}
+	}
+	for _, item := range containers {
+		if item.ImageID == id {
+			return nil, nil
+		}
+	}
+	return parent, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"ToyDocker/container"
+	"ToyDocker/image"
+	"ToyDocker/layer"
+	"ToyDocker/store"
+	"errors"
+	"testing"
+	"time"
+)
+
+// point the image, layer and container stores at temporary directories
+func setupImageStores(t *testing.T) {
+	imageStore = image.NewStore(t.TempDir())
+	layerStore = layer.NewStore(t.TempDir(), nil)
+	containerStore = store.New(t.TempDir())
+	t.Cleanup(func() {
+		imageStore, layerStore = nil, nil
+		containerStore = store.New(store.DefaultRoot)
+	})
+}
+
+// record a layerless image with the given parent and tags
+func recordImage(t *testing.T, parent, comment string, tags ...string) *image.Image {
+	img := &image.Image{Created: time.Unix(1700000000, 0).UTC(), Parent: parent, Comment: comment, OS: "linux"}
+	if _, err := imageStore.Create(img); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range tags {
+		ref, err := image.ParseReference(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := imageStore.Tag(ref, img.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return img
+}
+
+func imageExists(id string) bool {
+	_, err := imageStore.Get(id)
+	return !errors.Is(err, image.ErrNotFound)
+}
+
+func TestRemoveImageUsedByContainer(t *testing.T) {
+	setupImageStores(t)
+	img := recordImage(t, "", "", "web:1")
+	if err := containerStore.Create(&container.ContainerInfo{Id: "1234567890", Name: "web", ImageID: img.ID, Status: container.EXIT}); err != nil {
+		t.Fatal(err)
+	}
+	for _, force := range []bool{false, true} {
+		if err := removeImage("web:1", force); err == nil {
+			t.Errorf("removed an image a container uses with force %v", force)
+		}
+	}
+	if tags, _ := imageStore.Tags(img.ID); len(tags) != 1 {
+		t.Errorf("tags %v, want web:1 kept", tags)
+	}
+	if err := containerStore.Delete("1234567890"); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeImage("web:1", false); err != nil {
+		t.Fatal(err)
+	}
+	if imageExists(img.ID) {
+		t.Error("image kept once no container uses it")
+	}
+}
+
+func TestRemoveImageTags(t *testing.T) {
+	setupImageStores(t)
+	img := recordImage(t, "", "", "web:1", "web:latest")
+	if err := removeImage(img.ID, false); err == nil {
+		t.Error("removed an image with several tags by id without force")
+	}
+	// by one tag only that tag goes
+	if err := removeImage("web:latest", false); err != nil {
+		t.Fatal(err)
+	}
+	if tags, _ := imageStore.Tags(img.ID); len(tags) != 1 || !imageExists(img.ID) {
+		t.Errorf("tags %v, want web:1 kept", tags)
+	}
+	// with force every tag goes along
+	if err := imageStore.Tag(image.Reference{Name: "web", Tag: "2"}, img.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeImage(img.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if imageExists(img.ID) {
+		t.Error("force by id kept the image")
+	}
+}
+
+func TestRemoveImageWithChildren(t *testing.T) {
+	setupImageStores(t)
+	base := recordImage(t, "", "base", "base:1")
+	middle := recordImage(t, base.ID, "middle")
+	child := recordImage(t, middle.ID, "child", "child:1")
+	other := recordImage(t, "", "other")
+
+	for _, force := range []bool{false, true} {
+		if err := removeImage(base.ID, force); err == nil {
+			t.Errorf("removed an image with children by id with force %v", force)
+		}
+	}
+	// by its last tag it is only untagged
+	if err := removeImage("base:1", false); err != nil {
+		t.Fatal(err)
+	}
+	if !imageExists(base.ID) {
+		t.Fatal("image with children deleted")
+	}
+	// the child takes the untagged parents nothing else uses along
+	if err := removeImage("child:1", false); err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range []*image.Image{base, middle, child} {
+		if imageExists(img.ID) {
+			t.Errorf("image %s kept", img.Comment)
+		}
+	}
+	if !imageExists(other.ID) {
+		t.Error("unrelated image deleted")
+	}
+}
@@ -0,0 +1,44 @@
+package layer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Layer is one tar diff of an image, stored once however many images share it
+type Layer struct {
+	// sha256 of the parent's chain id and DiffID, names the layer together with all below it
+	ChainID string `json:"-"`
+	// sha256 of the uncompressed tar diff
+	DiffID string `json:"diffId"`
+	// chain id of the layer below, empty for a base layer
+	Parent string `json:"parent,omitempty"`
+	// storage driver layer holding the files
+	CacheID string `json:"cacheId"`
+	// bytes of the tar diff
+	Size int64 `json:"size"`
+	// images and child layers holding this layer, it is removed when none is left
+	References int `json:"references"`
+}
+
+// chain id of the layer with diffID on top of the layer with chain id parent
+func ChainID(parent, diffID string) string {
+	if parent == "" {
+		return diffID
+	}
+	return digest([]byte(parent + " " + diffID))
+}
+
+// chain id of the topmost of the layers with diffIDs, listed bottom up
+func ChainIDs(diffIDs []string) string {
+	chainID := ""
+	for _, diffID := range diffIDs {
+		chainID = ChainID(chainID, diffID)
+	}
+	return chainID
+}
+
+func digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
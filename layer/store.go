@@ -0,0 +1,245 @@
+package layer
+
+import (
+	"ToyDocker/fsutil"
+	"ToyDocker/graphdriver"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store keeps the layers of one storage driver addressed by chain id,
+// their records in <root>/sha256/<hex>.json and the tar diffs they came from,
+// which hash to their DiffID, in <root>/sha256/<hex>.tar, guarded by a flock on <root>/lock
+type Store struct {
+	root   string
+	driver graphdriver.Driver
+}
+
+func NewStore(root string, driver graphdriver.Driver) *Store {
+	return &Store{root: root, driver: driver}
+}
+
+func (s *Store) path(chainID string) string {
+	return filepath.Join(s.root, "sha256", strings.TrimPrefix(chainID, "sha256:")+".json")
+}
+
+func (s *Store) tarPath(chainID string) string {
+	return filepath.Join(s.root, "sha256", strings.TrimPrefix(chainID, "sha256:")+".tar")
+}
+
+func (s *Store) lock(exclusive bool) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Join(s.root, "sha256"), 0700); err != nil {
+		return nil, err
+	}
+	return fsutil.LockFile(filepath.Join(s.root, "lock"), exclusive)
+}
+
+func (s *Store) get(chainID string) (*Layer, error) {
+	content, err := ioutil.ReadFile(s.path(chainID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such layer: %s", chainID)
+		}
+		return nil, err
+	}
+	var l Layer
+	if err := json.Unmarshal(content, &l); err != nil {
+		return nil, fmt.Errorf("decode layer %s: %v", chainID, err)
+	}
+	l.ChainID = chainID
+	return &l, nil
+}
+
+func (s *Store) write(l *Layer) error {
+	content, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(s.path(l.ChainID), content, 0600)
+}
+
+// read the layer with chainID
+func (s *Store) Get(chainID string) (*Layer, error) {
+	lock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+	return s.get(chainID)
+}
+
+// unpack the tar diff on top of the layer with chain id parent, "" for a base layer.
+// The caller holds a reference on the returned layer and gives it back with Release.
+// A diff already stored on the same parent is not stored again.
+func (s *Store) Register(diff io.Reader, parent string) (*Layer, error) {
+	lock, err := s.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+
+	var parentLayer *Layer
+	parentCacheID := ""
+	if parent != "" {
+		if parentLayer, err = s.get(parent); err != nil {
+			return nil, err
+		}
+		parentCacheID = parentLayer.CacheID
+	}
+	cacheID, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.driver.Create(cacheID, parentCacheID); err != nil {
+		return nil, err
+	}
+	// keep the diff as it came, regenerating it would not give the same bytes
+	tarFile, err := ioutil.TempFile(filepath.Join(s.root, "sha256"), ".diff")
+	if err != nil {
+		s.driver.Remove(cacheID)
+		return nil, err
+	}
+	defer os.Remove(tarFile.Name())
+	defer tarFile.Close()
+	digester := sha256.New()
+	tee := io.TeeReader(diff, io.MultiWriter(digester, tarFile))
+	size, err := s.driver.ApplyDiff(cacheID, parentCacheID, tee)
+	if err == nil {
+		// tar may stop before the padding at the end, the digest covers all of it
+		var rest int64
+		rest, err = io.Copy(ioutil.Discard, tee)
+		size += rest
+	}
+	if err == nil {
+		err = tarFile.Sync()
+	}
+	if err != nil {
+		s.driver.Remove(cacheID)
+		return nil, err
+	}
+	diffID := "sha256:" + hex.EncodeToString(digester.Sum(nil))
+	chainID := ChainID(parent, diffID)
+
+	if existing, err := s.get(chainID); err == nil {
+		s.driver.Remove(cacheID)
+		existing.References++
+		if err := s.write(existing); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+	l := &Layer{
+		ChainID:    chainID,
+		DiffID:     diffID,
+		Parent:     parent,
+		CacheID:    cacheID,
+		Size:       size,
+		References: 1,
+	}
+	// the new layer holds its parent
+	if parentLayer != nil {
+		parentLayer.References++
+		if err := s.write(parentLayer); err != nil {
+			s.driver.Remove(cacheID)
+			return nil, err
+		}
+	}
+	if err := os.Rename(tarFile.Name(), s.tarPath(chainID)); err != nil {
+		s.driver.Remove(cacheID)
+		return nil, err
+	}
+	if err := s.write(l); err != nil {
+		os.Remove(s.tarPath(chainID))
+		s.driver.Remove(cacheID)
+		return nil, err
+	}
+	return l, nil
+}
+
+// take another reference on the layer with chainID, for an image built on it
+func (s *Store) Acquire(chainID string) (*Layer, error) {
+	lock, err := s.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+	l, err := s.get(chainID)
+	if err != nil {
+		return nil, err
+	}
+	l.References++
+	if err := s.write(l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// give back a reference on the layer with chainID.
+// Layers nobody holds any more are removed, and their parents released in turn.
+func (s *Store) Release(chainID string) error {
+	lock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer fsutil.UnlockFile(lock)
+	for chainID != "" {
+		l, err := s.get(chainID)
+		if err != nil {
+			return err
+		}
+		l.References--
+		if l.References > 0 {
+			return s.write(l)
+		}
+		if err := s.driver.Remove(l.CacheID); err != nil {
+			return err
+		}
+		if err := os.Remove(s.tarPath(chainID)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(s.path(chainID)); err != nil {
+			return err
+		}
+		chainID = l.Parent
+	}
+	return nil
+}
+
+// the tar diff the layer with chainID was registered from, it hashes to its DiffID
+func (s *Store) TarStream(chainID string) (io.ReadCloser, error) {
+	lock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+	if _, err := s.get(chainID); err != nil {
+		return nil, err
+	}
+	// a diff generated again from the driver would not hash to the DiffID
+	// save and push announce, so there is no falling back to one
+	f, err := os.Open(s.tarPath(chainID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("tar diff of layer %s is missing, pull or load the image again", chainID)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// name for a storage driver layer
+func randomID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
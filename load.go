@@ -0,0 +1,274 @@
+package main
+
+import (
+	"ToyDocker/image"
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// one layer blob of an image to load
+type layerBlob struct {
+	path string
+	// digest of the blob, not checked when empty
+	digest string
+}
+
+// load the images of an OCI image layout or docker save tar from input, stdin when empty
+func loadImages(input string) error {
+	in := os.Stdin
+	if input != "" {
+		var err error
+		if in, err = os.Open(input); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	if err := os.MkdirAll(filepath.Join(config.Root, "tmp"), 0700); err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir(filepath.Join(config.Root, "tmp"), "load")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := extractLayout(in, dir); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		var index image.Index
+		if err := readLayoutJSON(dir, "index.json", "", &index); err != nil {
+			return err
+		}
+		for _, descriptor := range index.Manifests {
+			if err := loadDescriptor(dir, descriptor, descriptorName(descriptor)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var manifests []image.LegacyManifest
+	if err := readLayoutJSON(dir, "manifest.json", "", &manifests); err != nil {
+		return fmt.Errorf("neither an OCI image layout nor a docker save archive: %v", err)
+	}
+	for _, manifest := range manifests {
+		var layers []layerBlob
+		for _, path := range manifest.Layers {
+			layers = append(layers, layerBlob{path: path})
+		}
+		if err := loadImage(dir, manifest.Config, "", layers, manifest.RepoTags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the name:tag an index entry gives its image, if any
+func descriptorName(descriptor image.Descriptor) []string {
+	if name := descriptor.Annotations[image.AnnotationImageName]; name != "" {
+		return []string{name}
+	}
+	// a bare tag names no repository
+	if name := descriptor.Annotations[image.AnnotationRefName]; strings.ContainsAny(name, ":/") {
+		return []string{name}
+	}
+	return nil
+}
+
+// load the image a manifest or, for several platforms, an index points at
+func loadDescriptor(dir string, descriptor image.Descriptor, tags []string) error {
+	switch descriptor.MediaType {
+	case image.MediaTypeLayoutIndex, image.MediaTypeDockerManifestList:
+		var index image.Index
+		if err := readLayoutJSON(dir, blobPath(descriptor.Digest), descriptor.Digest, &index); err != nil {
+			return err
+		}
+		chosen, err := choosePlatform(index)
+		if err != nil {
+			return err
+		}
+		return loadDescriptor(dir, chosen, tags)
+	case image.MediaTypeManifest, image.MediaTypeDockerManifest:
+		var manifest image.Manifest
+		if err := readLayoutJSON(dir, blobPath(descriptor.Digest), descriptor.Digest, &manifest); err != nil {
+			return err
+		}
+		var layers []layerBlob
+		for _, l := range manifest.Layers {
+			layers = append(layers, layerBlob{path: blobPath(l.Digest), digest: l.Digest})
+		}
+		return loadImage(dir, blobPath(manifest.Config.Digest), manifest.Config.Digest, layers, tags)
+	}
+	return fmt.Errorf("unsupported media type %s", descriptor.MediaType)
+}
+
+// the manifest for this host out of an index for several platforms
+func choosePlatform(index image.Index) (image.Descriptor, error) {
+	for _, descriptor := range index.Manifests {
+		if descriptor.Platform == nil ||
+			(descriptor.Platform.OS == runtime.GOOS && descriptor.Platform.Architecture == runtime.GOARCH) {
+			return descriptor, nil
+		}
+	}
+	return image.Descriptor{}, fmt.Errorf("no image for %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// register the layers of an image, record its config and tag it
+func loadImage(dir, configPath, configDigest string, layers []layerBlob, tags []string) error {
+	content, err := readLayoutFile(dir, configPath, configDigest)
+	if err != nil {
+		return err
+	}
+	var imageConfig image.Image
+	if err := json.Unmarshal(content, &imageConfig); err != nil {
+		return fmt.Errorf("decode image config: %v", err)
+	}
+	if len(imageConfig.RootFS.DiffIDs) != len(layers) {
+		return fmt.Errorf("image config lists %d layers, the manifest %d", len(imageConfig.RootFS.DiffIDs), len(layers))
+	}
+
+	held, err := registerLayers(imageConfig.RootFS.DiffIDs, func(i int) (io.ReadCloser, error) {
+		return openLayer(dir, layers[i])
+	})
+	if err != nil {
+		return err
+	}
+	return storeImageConfig(content, held, tags, "Loaded image")
+}
+
+// unpack the archive in r into dir, which may be gzip compressed
+func extractLayout(r io.Reader, dir string) error {
+	reader, err := maybeGunzip(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %v", err)
+		}
+		target := layoutPath(dir, hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			// docker save links layers shared between images
+			link := hdr.Linkname
+			if hdr.Typeflag == tar.TypeSymlink {
+				link = filepath.Join(filepath.Dir(hdr.Name), link)
+			}
+			source := layoutPath(dir, link)
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// path of name inside dir, names like ../x are kept inside too
+func layoutPath(dir, name string) string {
+	return filepath.Join(dir, filepath.Clean("/"+name))
+}
+
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
+
+// read a file of the layout, checking it against digest unless empty
+func readLayoutFile(dir, name, digest string) ([]byte, error) {
+	path := layoutPath(dir, name)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", name, err)
+	}
+	if digest != "" && image.Digest(content) != digest {
+		return nil, fmt.Errorf("%s does not match digest %s", name, digest)
+	}
+	return content, nil
+}
+
+func readLayoutJSON(dir, name, digest string, value interface{}) error {
+	content, err := readLayoutFile(dir, name, digest)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, value); err != nil {
+		return fmt.Errorf("decode %s: %v", name, err)
+	}
+	return nil
+}
+
+// the uncompressed tar diff of a layer blob, after checking its digest
+func openLayer(dir string, blob layerBlob) (io.ReadCloser, error) {
+	path := layoutPath(dir, blob.path)
+	if blob.digest != "" {
+		if err := verifyFile(path, blob.digest); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := maybeGunzip(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, f}, nil
+}
+
+func verifyFile(path, digest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	actual, err := image.DigestReader(f)
+	if err != nil {
+		return err
+	}
+	if actual != digest {
+		return fmt.Errorf("%s does not match digest %s", filepath.Base(path), digest)
+	}
+	return nil
+}
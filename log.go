@@ -0,0 +1,127 @@
+package main
+
+import (
+	"ToyDocker/container"
+	"ToyDocker/logdriver"
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func logContainer(containerInfo *container.ContainerInfo, follow bool) error {
+	driver, err := newLogDriver(containerInfo)
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+
+	return driver.ReadLogs(os.Stdout, os.Stderr, logdriver.ReadConfig{
+		Follow: follow,
+		Running: func() bool {
+			return containerRunning(containerInfo.Id)
+		},
+	})
+}
+
+// the log driver recorded for the container
+func newLogDriver(containerInfo *container.ContainerInfo) (logdriver.LogDriver, error) {
+	return logdriver.New(containerInfo.LogDriver, logdriver.Info{
+		ContainerID:   containerInfo.Id,
+		ContainerName: containerInfo.Name,
+		LogPath:       filepath.Join(containerStore.Dir(containerInfo.Id), container.ContainerLogFile),
+		Options:       containerInfo.LogOpts,
+	})
+}
+
+// point the container's stdout and stderr at new pipes,
+// returning the read ends for the logger
+func wireLogPipes(parent *exec.Cmd) ([]*os.File, error) {
+	stdoutRead, stdoutWrite, err := container.NewPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderrRead, stderrWrite, err := container.NewPipe()
+	if err != nil {
+		stdoutRead.Close()
+		stdoutWrite.Close()
+		return nil, err
+	}
+	parent.Stdout = stdoutWrite
+	parent.Stderr = stderrWrite
+	return []*os.File{stdoutRead, stderrRead}, nil
+}
+
+// start the logger process that outlives us and feeds the log driver.
+// logPipes are handed over as fd 3 (stdout) and fd 4 (stderr).
+func startLogger(containerID string, logPipes []*os.File) error {
+	args := append(config.args(), "logger", containerID)
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.ExtraFiles = logPipes
+	// own session, so it is not killed together with our terminal
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err := cmd.Start()
+	// the logger and the container hold their own copies now
+	for _, pipe := range logPipes {
+		pipe.Close()
+	}
+	return err
+}
+
+// body of the logger process: copy both streams into the driver until the container closes them
+func runLogger(containerID string) error {
+	containerInfo, err := containerStore.Get(containerID)
+	if err != nil {
+		return fmt.Errorf("Get container %s info error %v", containerID, err)
+	}
+	driver, err := newLogDriver(containerInfo)
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, source := range []string{logdriver.Stdout, logdriver.Stderr} {
+		pipe := os.NewFile(uintptr(3+i), source)
+		wg.Add(1)
+		go func(pipe *os.File, source string) {
+			defer wg.Done()
+			defer pipe.Close()
+			copyToLogDriver(pipe, source, driver, &mu)
+		}(pipe, source)
+	}
+	wg.Wait()
+	return nil
+}
+
+func copyToLogDriver(r io.Reader, source string, driver logdriver.LogDriver, mu *sync.Mutex) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			mu.Lock()
+			logErr := driver.Log(&logdriver.Message{
+				Line:      line,
+				Source:    source,
+				Timestamp: time.Now(),
+			})
+			mu.Unlock()
+			if logErr != nil {
+				logrus.Errorf("Log %s line error %v", source, logErr)
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				logrus.Errorf("Read %s error %v", source, err)
+			}
+			return
+		}
+	}
+}
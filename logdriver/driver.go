@@ -0,0 +1,104 @@
+package logdriver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// source of a message, the stream the container wrote it to
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+// driver used when --log-driver is not given
+const DefaultDriver = "json-file"
+
+// one line of container output
+type Message struct {
+	Line      []byte
+	Source    string
+	Timestamp time.Time
+}
+
+// what a driver needs to know about the container it logs for
+type Info struct {
+	ContainerID   string
+	ContainerName string
+	// file the driver keeps its log in, if it keeps one
+	LogPath string
+	// values of --log-opt
+	Options map[string]string
+}
+
+type ReadConfig struct {
+	// keep waiting for new messages until the container stops
+	Follow bool
+	// reports whether the container is still running, only used with Follow
+	Running func() bool
+}
+
+// log driver interface: container output is handed to Log line by line,
+// ReadLogs replays it for the logs command.
+// A driver is not safe for concurrent use.
+type LogDriver interface {
+	// return the name of the driver
+	Name() string
+	// record one message
+	Log(msg *Message) error
+	// write recorded messages to stdout or stderr according to their source
+	ReadLogs(stdout, stderr io.Writer, config ReadConfig) error
+	// flush and release whatever the driver holds open
+	Close() error
+}
+
+type creator func(info Info) (LogDriver, error)
+
+// all available log drivers
+var drivers = map[string]creator{
+	"json-file": newJSONFileDriver,
+	"local":     newLocalDriver,
+	"none":      newNoneDriver,
+	"syslog":    newSyslogDriver,
+}
+
+// create the driver called name, validating its options
+func New(name string, info Info) (LogDriver, error) {
+	if name == "" {
+		name = DefaultDriver
+	}
+	create, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown log driver %s, available: %v", name, Names())
+	}
+	return create(info)
+}
+
+// names of all available log drivers
+func Names() []string {
+	var names []string
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reject options the driver does not understand
+func checkOptions(driver string, options map[string]string, allowed ...string) error {
+	for key := range options {
+		known := false
+		for _, a := range allowed {
+			if key == a {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown log opt %s for %s log driver", key, driver)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,456 @@
+package logdriver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// how often follow mode polls the log file for new data
+const followInterval = 200 * time.Millisecond
+
+// log file shared by the file based drivers.
+// Once it grows past maxSize it is renamed to path.1 (path.1.gz when compressed),
+// older files are shifted up and at most maxFiles files are kept, counting the current one.
+type rotateFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	compress bool
+
+	file *os.File
+	size int64
+}
+
+// build a rotating file from the max-size, max-file and compress options,
+// falling back to the given defaults. maxSize 0 means never rotate.
+func newRotateFile(path string, options map[string]string, maxSize int64, maxFiles int, compress bool) (*rotateFile, error) {
+	if path == "" {
+		return nil, fmt.Errorf("log path is not set")
+	}
+	if value, ok := options["max-size"]; ok {
+		size, err := parseSize(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max-size %s: %v", value, err)
+		}
+		maxSize = size
+	}
+	if value, ok := options["max-file"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid max-file %s, it must be a number greater than 0", value)
+		}
+		if maxSize == 0 {
+			return nil, fmt.Errorf("max-file needs max-size to be set")
+		}
+		maxFiles = n
+	}
+	if value, ok := options["compress"]; ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compress %s: %v", value, err)
+		}
+		compress = b
+	}
+	return &rotateFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		compress: compress,
+	}, nil
+}
+
+// parse sizes like 1024, 512k, 10m or 1g
+func parseSize(value string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), "b")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		unit = 1 << 30
+	}
+	if unit != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("size must be a positive number with an optional k, m or g unit")
+	}
+	return n * unit, nil
+}
+
+// name of the i-th rotated file, 1 being the newest
+func (r *rotateFile) rotatedName(i int) string {
+	name := r.plainName(i)
+	if r.compress {
+		name += ".gz"
+	}
+	return name
+}
+
+// name of the i-th rotated file before compression
+func (r *rotateFile) plainName(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
+
+// write one record, rotating first if it would not fit.
+// A record is never split between two files.
+func (r *rotateFile) Write(p []byte) (int, error) {
+	if r.file == nil {
+		file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return 0, err
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return 0, err
+		}
+		r.file = file
+		r.size = stat.Size()
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotateFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	if r.maxFiles > 1 {
+		// drop the oldest file and shift the others up by one. A file that
+		// was never compressed, after a crash or a failed compression, is
+		// shifted along with the compressed ones.
+		for _, name := range []string{r.rotatedName(r.maxFiles - 1), r.plainName(r.maxFiles - 1)} {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		for i := r.maxFiles - 2; i >= 1; i-- {
+			if err := os.Rename(r.rotatedName(i), r.rotatedName(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if r.compress {
+				if err := os.Rename(r.plainName(i), r.plainName(i+1)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(r.path, r.plainName(1)); err != nil {
+			return err
+		}
+		if r.compress {
+			// on failure path.1 stays uncompressed, which readers fall back to,
+			// and the record that caused the rotation is still written
+			compressFile(r.plainName(1), r.rotatedName(1))
+		}
+	}
+	// with a single file the log is simply truncated
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.size = 0
+	return nil
+}
+
+// kept in the gzip header of a compressed file: the inode of the file it
+// was made from, so a follower still holding that file can find it again
+type rotatedMetadata struct {
+	Inode uint64 `json:"inode"`
+}
+
+// gzip src into dst and remove src
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	extra, err := json.Marshal(rotatedMetadata{Inode: inode(stat)})
+	if err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Extra = extra
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func (r *rotateFile) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// pass every record to fn, oldest rotated file first.
+// split cuts the byte stream into records the same way bufio.Scanner does.
+func (r *rotateFile) read(split bufio.SplitFunc, config ReadConfig, fn func(record []byte) error) error {
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		if err := r.readRotated(i, split, fn); err != nil {
+			return err
+		}
+	}
+	return r.tail(split, config, fn)
+}
+
+// read the i-th rotated file, compressed or not
+func (r *rotateFile) readRotated(i int, split bufio.SplitFunc, fn func(record []byte) error) error {
+	compressed := r.compress
+	file, err := os.Open(r.rotatedName(i))
+	if os.IsNotExist(err) && compressed {
+		// left uncompressed by a crash or a failed compression
+		compressed = false
+		file, err = os.Open(r.plainName(i))
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if compressed {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("open %s: %v", file.Name(), err)
+		}
+		defer zr.Close()
+		reader = zr
+	}
+	t := &tailer{split: split, fn: fn}
+	if err := t.drain(reader); err != nil {
+		return err
+	}
+	return t.flush()
+}
+
+// read the log file, and with config.Follow keep waiting for new records
+// until the container stops. The file is reopened when it has been rotated
+// (replaced by a new file) and reread from the start when it has been truncated.
+func (r *rotateFile) tail(split bufio.SplitFunc, config ReadConfig, fn func(record []byte) error) error {
+	path := r.path
+	t := &tailer{split: split, fn: fn}
+	var file *os.File
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		if file == nil {
+			f, err := os.Open(path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err == nil {
+				file = f
+			}
+		}
+		if file != nil {
+			if err := t.drain(file); err != nil {
+				return err
+			}
+		}
+		if !config.Follow {
+			return t.flush()
+		}
+		// check liveness before looking for rotation, so that everything
+		// written before the container stopped is still drained below
+		running := config.Running != nil && config.Running()
+
+		if file != nil {
+			current, err := os.Stat(path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			opened, err := file.Stat()
+			if err != nil {
+				return err
+			}
+			if current != nil && !os.SameFile(current, opened) {
+				// rotated: finish the old file and the ones rotated after it
+				// since the last poll, then switch to the new one
+				if err := t.drain(file); err != nil {
+					return err
+				}
+				if err := t.flush(); err != nil {
+					return err
+				}
+				file.Close()
+				file = nil
+				for i := r.rotatedIndex(opened) - 1; i >= 1; i-- {
+					if err := r.readRotated(i, split, fn); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			offset, err := file.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			if current != nil && current.Size() < offset {
+				// truncated in place, start over
+				if _, err := file.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				t.buf = nil
+				continue
+			}
+		}
+
+		if !running {
+			if file != nil {
+				if err := t.drain(file); err != nil {
+					return err
+				}
+			}
+			return t.flush()
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+// position the given file has been rotated to. Files dropped off the end
+// give maxFiles, as every rotated file left is newer than them.
+func (r *rotateFile) rotatedIndex(opened os.FileInfo) int {
+	ino := inode(opened)
+	for i := 1; i < r.maxFiles; i++ {
+		// not yet compressed, or never compressed
+		if stat, err := os.Stat(r.plainName(i)); err == nil && os.SameFile(stat, opened) {
+			return i
+		}
+		if r.compress && ino != 0 && compressedFrom(r.rotatedName(i)) == ino {
+			return i
+		}
+	}
+	return r.maxFiles
+}
+
+// inode of the file a compressed file was made from, 0 if unknown
+func compressedFrom(name string) uint64 {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return 0
+	}
+	defer zr.Close()
+	var metadata rotatedMetadata
+	if json.Unmarshal(zr.Extra, &metadata) != nil {
+		return 0
+	}
+	return metadata.Inode
+}
+
+func inode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Ino
+	}
+	return 0
+}
+
+// cuts a byte stream that may arrive in pieces into records
+type tailer struct {
+	split bufio.SplitFunc
+	fn    func(record []byte) error
+	buf   []byte
+}
+
+// read r to its current end, handing complete records to fn
+func (t *tailer) drain(r io.Reader) error {
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(chunk)
+		if n > 0 {
+			t.buf = append(t.buf, chunk[:n]...)
+			if err := t.emit(false); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// hand out whatever is left, no more data will follow
+func (t *tailer) flush() error {
+	err := t.emit(true)
+	t.buf = nil
+	return err
+}
+
+func (t *tailer) emit(atEOF bool) error {
+	for len(t.buf) > 0 {
+		advance, token, err := t.split(t.buf, atEOF)
+		if err != nil {
+			return err
+		}
+		if advance == 0 {
+			return nil
+		}
+		t.buf = t.buf[advance:]
+		if token != nil {
+			if err := t.fn(token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package logdriver
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a follower that sleeps through several rotations still reads every record
+func TestFollowRotations(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			// every record after the first rotates the file
+			r := &rotateFile{path: filepath.Join(t.TempDir(), "log"), maxSize: 10, maxFiles: 5, compress: compress}
+			defer r.Close()
+			write := func(record string) {
+				if _, err := r.Write([]byte(record + "\n")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			write("rec 1")
+
+			var running atomic.Bool
+			running.Store(true)
+			records := make(chan string, 10)
+			done := make(chan error, 1)
+			go func() {
+				done <- r.read(bufio.ScanLines, ReadConfig{Follow: true, Running: running.Load}, func(record []byte) error {
+					records <- string(record)
+					return nil
+				})
+			}()
+
+			var got []string
+			select {
+			case record := <-records:
+				got = append(got, record)
+			case <-time.After(5 * time.Second):
+				t.Fatal("first record not read")
+			}
+			// well within one poll interval
+			write("rec 2")
+			write("rec 3")
+			write("rec 4")
+			time.Sleep(3 * followInterval)
+			write("rec 5")
+			running.Store(false)
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("follow did not stop")
+			}
+			close(records)
+			for record := range records {
+				got = append(got, record)
+			}
+			if want := []string{"rec 1", "rec 2", "rec 3", "rec 4", "rec 5"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("read %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// a rotated file left uncompressed by a crash is read and shifted like the others
+func TestUncompressedRotated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	r := &rotateFile{path: path, maxSize: 10, maxFiles: 5, compress: true}
+	write := func(r *rotateFile, record string) {
+		if _, err := r.Write([]byte(record + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readAll := func(r *rotateFile) []string {
+		var got []string
+		if err := r.read(bufio.ScanLines, ReadConfig{}, func(record []byte) error {
+			got = append(got, string(record))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	write(r, "rec 1")
+	write(r, "rec 2")
+	r.Close()
+	// crash after renaming the log to path.1, before compressing it
+	if err := os.Rename(r.rotatedName(1), r.rotatedName(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path, r.plainName(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	r = &rotateFile{path: path, maxSize: 10, maxFiles: 5, compress: true}
+	defer r.Close()
+	write(r, "rec 3")
+	if got, want := readAll(r), []string{"rec 1", "rec 2", "rec 3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	write(r, "rec 4")
+	if _, err := os.Stat(r.plainName(2)); err != nil {
+		t.Errorf("uncompressed file not shifted: %v", err)
+	}
+	if got, want := readAll(r), []string{"rec 1", "rec 2", "rec 3", "rec 4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("read %q, want %q", got, want)
+	}
+}
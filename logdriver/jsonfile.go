@@ -0,0 +1,69 @@
+package logdriver
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// json-file driver writes one json object per line, the same format docker uses
+type jsonFileDriver struct {
+	file *rotateFile
+}
+
+type jsonLog struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+func newJSONFileDriver(info Info) (LogDriver, error) {
+	if err := checkOptions("json-file", info.Options, "max-size", "max-file", "compress"); err != nil {
+		return nil, err
+	}
+	// unlimited by default
+	file, err := newRotateFile(info.LogPath, info.Options, 0, 1, false)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonFileDriver{file: file}, nil
+}
+
+func (d *jsonFileDriver) Name() string {
+	return "json-file"
+}
+
+func (d *jsonFileDriver) Log(msg *Message) error {
+	line, err := json.Marshal(&jsonLog{
+		Log:    string(msg.Line),
+		Stream: msg.Source,
+		Time:   msg.Timestamp.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = d.file.Write(append(line, '\n'))
+	return err
+}
+
+func (d *jsonFileDriver) ReadLogs(stdout, stderr io.Writer, config ReadConfig) error {
+	return d.file.read(bufio.ScanLines, config, func(record []byte) error {
+		var entry jsonLog
+		if err := json.Unmarshal(record, &entry); err != nil {
+			// plain text written before log drivers existed
+			_, err := stdout.Write(append(record, '\n'))
+			return err
+		}
+		w := stdout
+		if entry.Stream == Stderr {
+			w = stderr
+		}
+		_, err := io.WriteString(w, entry.Log)
+		return err
+	})
+}
+
+func (d *jsonFileDriver) Close() error {
+	return d.file.Close()
+}
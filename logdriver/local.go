@@ -0,0 +1,115 @@
+package logdriver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// local driver keeps a compact binary log, rotated and compressed by default.
+// Each record is laid out as
+//
+//	| size uint32 | timestamp int64 | source uint8 | line | size uint32 |
+//
+// where size counts the bytes between the two size fields, so the file
+// can be walked in both directions.
+type localDriver struct {
+	file *rotateFile
+}
+
+const (
+	localSourceStdout byte = 0
+	localSourceStderr byte = 1
+
+	// timestamp and source
+	localHeaderSize = 9
+)
+
+func newLocalDriver(info Info) (LogDriver, error) {
+	if err := checkOptions("local", info.Options, "max-size", "max-file", "compress"); err != nil {
+		return nil, err
+	}
+	file, err := newRotateFile(info.LogPath, info.Options, 20<<20, 5, true)
+	if err != nil {
+		return nil, err
+	}
+	return &localDriver{file: file}, nil
+}
+
+func (d *localDriver) Name() string {
+	return "local"
+}
+
+func (d *localDriver) Log(msg *Message) error {
+	size := localHeaderSize + len(msg.Line)
+	record := make([]byte, 4+size+4)
+	binary.BigEndian.PutUint32(record[0:4], uint32(size))
+	binary.BigEndian.PutUint64(record[4:12], uint64(msg.Timestamp.UnixNano()))
+	record[12] = localSourceStdout
+	if msg.Source == Stderr {
+		record[12] = localSourceStderr
+	}
+	copy(record[13:], msg.Line)
+	binary.BigEndian.PutUint32(record[4+size:], uint32(size))
+	// one write per record, so rotation never splits it
+	_, err := d.file.Write(record)
+	return err
+}
+
+func (d *localDriver) ReadLogs(stdout, stderr io.Writer, config ReadConfig) error {
+	return d.file.read(splitLocalRecord, config, func(record []byte) error {
+		msg, err := decodeLocalRecord(record)
+		if err != nil {
+			return err
+		}
+		w := stdout
+		if msg.Source == Stderr {
+			w = stderr
+		}
+		_, err = w.Write(msg.Line)
+		return err
+	})
+}
+
+func (d *localDriver) Close() error {
+	return d.file.Close()
+}
+
+// bufio.SplitFunc returning the bytes between the two size fields of a record
+func splitLocalRecord(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) < 4 {
+		if atEOF {
+			// a record cut short, e.g. when the logger was killed mid write
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	total := 4 + size + 4
+	if len(data) < total {
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+	if trailer := int(binary.BigEndian.Uint32(data[4+size : total])); trailer != size {
+		return 0, nil, fmt.Errorf("corrupt local log record, size %d does not match trailer %d", size, trailer)
+	}
+	return total, data[4 : 4+size], nil
+}
+
+func decodeLocalRecord(record []byte) (*Message, error) {
+	if len(record) < localHeaderSize {
+		return nil, fmt.Errorf("corrupt local log record of %d bytes", len(record))
+	}
+	msg := &Message{
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(record[0:8]))),
+		Source:    Stdout,
+		Line:      record[localHeaderSize:],
+	}
+	if record[8] == localSourceStderr {
+		msg.Source = Stderr
+	}
+	return msg, nil
+}
@@ -0,0 +1,33 @@
+package logdriver
+
+import (
+	"fmt"
+	"io"
+)
+
+// none driver drops all output
+type noneDriver struct {
+}
+
+func newNoneDriver(info Info) (LogDriver, error) {
+	if err := checkOptions("none", info.Options); err != nil {
+		return nil, err
+	}
+	return &noneDriver{}, nil
+}
+
+func (d *noneDriver) Name() string {
+	return "none"
+}
+
+func (d *noneDriver) Log(msg *Message) error {
+	return nil
+}
+
+func (d *noneDriver) ReadLogs(stdout, stderr io.Writer, config ReadConfig) error {
+	return fmt.Errorf("configured logging driver does not support reading")
+}
+
+func (d *noneDriver) Close() error {
+	return nil
+}
@@ -0,0 +1,230 @@
+package logdriver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// default address: the local syslog daemon
+const defaultSyslogAddress = "unix:///dev/log"
+
+// structured data id carrying container details, in the enterprise number form RFC 5424 requires
+const syslogSDID = "container@32473"
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// severities used for the two streams
+const (
+	syslogSeverityErr  = 3
+	syslogSeverityInfo = 6
+)
+
+// syslog driver forwards every line as an RFC 5424 message
+type syslogDriver struct {
+	network  string
+	address  string
+	facility int
+	tag      string
+	hostname string
+	info     Info
+
+	conn net.Conn
+}
+
+func newSyslogDriver(info Info) (LogDriver, error) {
+	if err := checkOptions("syslog", info.Options, "syslog-address", "syslog-facility", "tag"); err != nil {
+		return nil, err
+	}
+	address := info.Options["syslog-address"]
+	if address == "" {
+		address = defaultSyslogAddress
+	}
+	network, addr, err := parseSyslogAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	facility := syslogFacilities["daemon"]
+	if name, ok := info.Options["syslog-facility"]; ok {
+		f, ok := syslogFacilities[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid syslog-facility %s", name)
+		}
+		facility = f
+	}
+	tag := info.Options["tag"]
+	if tag == "" {
+		tag = info.ContainerName
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &syslogDriver{
+		network:  network,
+		address:  addr,
+		facility: facility,
+		tag:      tag,
+		hostname: hostname,
+		info:     info,
+	}, nil
+}
+
+// split syslog-address into a network and an address for net.Dial,
+// e.g. udp://127.0.0.1:514, tcp://host:514, unix:///dev/log or unixgram:///dev/log
+func parseSyslogAddress(address string) (string, string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog-address %s: %v", address, err)
+	}
+	switch u.Scheme {
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("invalid syslog-address %s, missing socket path", address)
+		}
+		return u.Scheme, u.Path, nil
+	case "udp", "tcp":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "514")
+		}
+		if u.Hostname() == "" {
+			return "", "", fmt.Errorf("invalid syslog-address %s, missing host", address)
+		}
+		return u.Scheme, host, nil
+	default:
+		return "", "", fmt.Errorf("invalid syslog-address %s, scheme must be unix, unixgram, udp or tcp", address)
+	}
+}
+
+func (d *syslogDriver) Name() string {
+	return "syslog"
+}
+
+func (d *syslogDriver) connect() error {
+	if d.network == "unix" {
+		// /dev/log is usually a datagram socket, fall back to a stream one
+		conn, err := net.Dial("unixgram", d.address)
+		if err == nil {
+			d.conn = conn
+			d.network = "unixgram"
+			return nil
+		}
+	}
+	conn, err := net.Dial(d.network, d.address)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+	return nil
+}
+
+func (d *syslogDriver) Log(msg *Message) error {
+	if d.conn == nil {
+		if err := d.connect(); err != nil {
+			return err
+		}
+	}
+	frame := d.frame(d.format(msg))
+	if _, err := d.conn.Write(frame); err != nil {
+		// the daemon may have restarted, reconnect once
+		d.conn.Close()
+		d.conn = nil
+		if err := d.connect(); err != nil {
+			return err
+		}
+		_, err = d.conn.Write(frame)
+		return err
+	}
+	return nil
+}
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+func (d *syslogDriver) format(msg *Message) []byte {
+	severity := syslogSeverityInfo
+	if msg.Source == Stderr {
+		severity = syslogSeverityErr
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s - - [%s id=\"%s\" name=\"%s\"] ",
+		d.facility*8+severity,
+		msg.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(d.hostname, 255),
+		syslogHeaderField(d.tag, 48),
+		syslogSDID,
+		escapeSDParam(d.info.ContainerID),
+		escapeSDParam(d.info.ContainerName))
+	buf.Write(bytes.TrimRight(msg.Line, "\r\n"))
+	return buf.Bytes()
+}
+
+// stream transports need framing, datagrams carry one message each
+func (d *syslogDriver) frame(message []byte) []byte {
+	switch d.network {
+	case "tcp":
+		// octet counting, RFC 6587
+		return append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+	case "unix":
+		return append(message, '\n')
+	}
+	return message
+}
+
+// header fields are printable ascii without spaces, "-" when empty
+func syslogHeaderField(value string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+func escapeSDParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+func (d *syslogDriver) ReadLogs(stdout, stderr io.Writer, config ReadConfig) error {
+	return fmt.Errorf("configured logging driver does not support reading")
+}
+
+func (d *syslogDriver) Close() error {
+	if d.conn == nil {
+		return nil
+	}
+	err := d.conn.Close()
+	d.conn = nil
+	return err
+}
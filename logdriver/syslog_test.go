@@ -0,0 +1,216 @@
+package logdriver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	testContainerID   = "4f1c9ab2e7d0b6c8a3f5e9d1c7b2a4e6f8d0c2b4a6e8f0d2c4b6a8e0f2d4c6b8"
+	testContainerName = "focused_turing"
+)
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME - - [SD] MSG
+var syslogMessage = regexp.MustCompile(`^<(\d+)>1 (\S+) (\S+) (\S+) - - \[container@32473 id="([^"]*)" name="([^"]*)"\] (.*)$`)
+
+// read the next message from a listener, without transport framing
+type messageReader func() (string, error)
+
+func TestSyslogDriver(t *testing.T) {
+	tests := []struct {
+		network string
+		listen  func(t *testing.T) (string, messageReader)
+	}{
+		{"unixgram", listenUnixgram},
+		{"udp", listenUDP},
+		{"tcp", listenTCP},
+	}
+	for _, test := range tests {
+		t.Run(test.network, func(t *testing.T) {
+			address, read := test.listen(t)
+			driver, err := New("syslog", Info{
+				ContainerID:   testContainerID,
+				ContainerName: testContainerName,
+				Options: map[string]string{
+					"syslog-address":  address,
+					"syslog-facility": "local0",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer driver.Close()
+
+			timestamp := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC)
+			messages := []struct {
+				msg      Message
+				priority int
+			}{
+				// local0 is facility 16, info 6 and err 3
+				{Message{Line: []byte("hello world\n"), Source: Stdout, Timestamp: timestamp}, 16*8 + 6},
+				{Message{Line: []byte("it failed\n"), Source: Stderr, Timestamp: timestamp}, 16*8 + 3},
+			}
+			for _, m := range messages {
+				msg := m.msg
+				if err := driver.Log(&msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, m := range messages {
+				received, err := read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				fields := syslogMessage.FindStringSubmatch(received)
+				if fields == nil {
+					t.Fatalf("%q is not an RFC 5424 message", received)
+				}
+				if fields[1] != strconv.Itoa(m.priority) {
+					t.Errorf("priority %s, want %d", fields[1], m.priority)
+				}
+				if fields[2] != "2024-05-01T12:30:00.123456Z" {
+					t.Errorf("timestamp %s", fields[2])
+				}
+				if fields[4] != testContainerName {
+					t.Errorf("tag %s, want the container name %s", fields[4], testContainerName)
+				}
+				if fields[5] != testContainerID || fields[6] != testContainerName {
+					t.Errorf("structured data id=%s name=%s", fields[5], fields[6])
+				}
+				if want := string(m.msg.Line[:len(m.msg.Line)-1]); fields[7] != want {
+					t.Errorf("message %q, want %q", fields[7], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSyslogTag(t *testing.T) {
+	address, read := listenUDP(t)
+	driver, err := New("syslog", Info{
+		ContainerID:   testContainerID,
+		ContainerName: testContainerName,
+		Options:       map[string]string{"syslog-address": address, "tag": "web app"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	if err := driver.Log(&Message{Line: []byte("x"), Source: Stdout, Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	received, err := read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := syslogMessage.FindStringSubmatch(received)
+	if fields == nil {
+		t.Fatalf("%q is not an RFC 5424 message", received)
+	}
+	// daemon is facility 3
+	if fields[1] != strconv.Itoa(3*8+6) {
+		t.Errorf("priority %s, want the daemon facility", fields[1])
+	}
+	if fields[4] != "web_app" {
+		t.Errorf("tag %s, want web_app", fields[4])
+	}
+}
+
+func TestParseSyslogAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		addr    string
+		invalid bool
+	}{
+		{address: "udp://127.0.0.1", network: "udp", addr: "127.0.0.1:514"},
+		{address: "tcp://logs.example.com:6514", network: "tcp", addr: "logs.example.com:6514"},
+		{address: "unix:///dev/log", network: "unix", addr: "/dev/log"},
+		{address: "unixgram:///run/log", network: "unixgram", addr: "/run/log"},
+		{address: "unix://", invalid: true},
+		{address: "udp://:514", invalid: true},
+		{address: "http://127.0.0.1", invalid: true},
+	}
+	for _, test := range tests {
+		network, addr, err := parseSyslogAddress(test.address)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s: accepted", test.address)
+			}
+			continue
+		}
+		if err != nil || network != test.network || addr != test.addr {
+			t.Errorf("%s: %s %s %v, want %s %s", test.address, network, addr, err, test.network, test.addr)
+		}
+	}
+}
+
+func listenUnixgram(t *testing.T) (string, messageReader) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return "unixgram://" + path, packetReader(conn)
+}
+
+func listenUDP(t *testing.T) (string, messageReader) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return "udp://" + conn.LocalAddr().String(), packetReader(conn)
+}
+
+// every datagram is one message
+func packetReader(conn net.PacketConn) messageReader {
+	return func() (string, error) {
+		buf := make([]byte, 64*1024)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		return string(buf[:n]), err
+	}
+}
+
+// messages on a stream are octet counted: LENGTH SP MESSAGE
+func listenTCP(t *testing.T) (string, messageReader) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var reader *bufio.Reader
+	return "tcp://" + listener.Addr().String(), func() (string, error) {
+		if reader == nil {
+			conn, err := listener.Accept()
+			if err != nil {
+				return "", err
+			}
+			t.Cleanup(func() { conn.Close() })
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			reader = bufio.NewReader(conn)
+		}
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return "", err
+		}
+		n, err := strconv.Atoi(length[:len(length)-1])
+		if err != nil {
+			return "", fmt.Errorf("frame does not start with an octet count: %q", length)
+		}
+		message := make([]byte, n)
+		if _, err := io.ReadFull(reader, message); err != nil {
+			return "", err
+		}
+		return string(message), nil
+	}
+}
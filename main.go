@@ -1,31 +1,78 @@
 package main
 
 import (
+	"ToyDocker/graphdriver"
+	"ToyDocker/store"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"os"
+	"strings"
 )
 
 const usage = `go-docker`
 
+// records of all containers, moved to the exec root by loadConfig
+var containerStore = store.New(store.DefaultRoot)
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "toy-docker"
 	app.Usage = usage
 
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "config file with root, exec-root and storage-driver, flags take precedence",
+			Value: defaultConfigFile,
+		},
+		cli.StringFlag{
+			Name:  "root",
+			Usage: "data root the layers and images are kept in (default " + graphdriver.DefaultRoot + ")",
+		},
+		cli.StringFlag{
+			Name:  "exec-root",
+			Usage: "root of the container state and logs (default " + store.DefaultRoot + ")",
+		},
+		cli.StringFlag{
+			Name:  "storage-driver",
+			Usage: "storage driver to use: " + strings.Join(graphdriver.Names(), ", ") + ", picked automatically when empty",
+		},
+	}
+
 	app.Commands = []cli.Command{
 		runCommand,
 		initCommand,
 		commitCommand,
+		diffCommand,
+		copyCommand,
 		listCommand,
+		inspectCommand,
 		logCommand,
+		loggerCommand,
+		renameCommand,
+		removeCommand,
+		pruneCommand,
+		infoCommand,
+		buildCommand,
+		imagesCommand,
+		imageCommand,
+		historyCommand,
+		removeImageCommand,
+		tagCommand,
+		saveCommand,
+		loadCommand,
+		exportCommand,
+		importCommand,
+		pullCommand,
+		pushCommand,
 	}
 
 	app.Before = func(context *cli.Context) error {
 		logrus.SetFormatter(&logrus.JSONFormatter{})
 
-		logrus.SetOutput(os.Stdout)
-		return nil
+		// stdout is left to command output like ps -q and --format json
+		logrus.SetOutput(os.Stderr)
+		return loadConfig(context)
 	}
 	if err := app.Run(os.Args); err != nil {
 		logrus.Fatal(err)
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"ToyDocker/container"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// length commands are cut to unless --no-trunc is given
+const psCommandLength = 20
+
+type psOptions struct {
+	all   bool
+	quiet bool
+	// filter key to accepted values, see matchFilters
+	filters map[string][]string
+	// Go template, or "json"
+	format  string
+	noTrunc bool
+	// unmount what dead containers left behind
+	cleanup bool
+}
+
+// keys accepted by --filter
+var filterKeys = []string{"id", "name", "status", "label", "ancestor", "exited"}
+
+// what a --format template sees for one container
+type psContext struct {
+	ID        string
+	Name      string
+	Image     string
+	Command   string
+	CreatedAt string
+	Status    string
+	Pid       string
+	ExitCode  int
+	Labels    map[string]string
+}
+
+// value of one label, for {{.Label "team"}}
+func (c psContext) Label(key string) string {
+	return c.Labels[key]
+}
+
+func ListContainers(opts psOptions) error {
+	containers, err := containerStore.List()
+	if err != nil {
+		return fmt.Errorf("List containers error %v", err)
+	}
+	containers = reconcileContainers(containers, opts.cleanup)
+	// newest first
+	sort.SliceStable(containers, func(i, j int) bool {
+		return containers[i].CreateTime > containers[j].CreateTime
+	})
+
+	// filtering on a status implies looking at stopped containers too
+	all := opts.all || len(opts.filters["status"]) > 0 || len(opts.filters["exited"]) > 0
+	var shown []*container.ContainerInfo
+	for _, item := range containers {
+		if !all && item.Status != container.RUNNING {
+			continue
+		}
+		if !matchFilters(item, opts.filters) {
+			continue
+		}
+		shown = append(shown, item)
+	}
+
+	switch {
+	case opts.quiet:
+		for _, item := range shown {
+			fmt.Println(newPsContext(item, opts.noTrunc).ID)
+		}
+		return nil
+	case opts.format == "json":
+		for _, item := range shown {
+			line, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(line))
+		}
+		return nil
+	case opts.format != "":
+		tmpl, err := template.New("ps").Parse(opts.format)
+		if err != nil {
+			return fmt.Errorf("invalid format template: %v", err)
+		}
+		for _, item := range shown {
+			if err := tmpl.Execute(os.Stdout, newPsContext(item, opts.noTrunc)); err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+
+	// use tabwriter.NewWriter() to print print container info
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	// output info
+	fmt.Fprint(w, "ID\tNAME\tIMAGE\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+	for _, item := range shown {
+		c := newPsContext(item, opts.noTrunc)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			c.ID,
+			c.Name,
+			c.Image,
+			c.Pid,
+			c.Status,
+			c.Command,
+			c.CreatedAt)
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("Flush error %v", err)
+	}
+	return nil
+}
+
+func newPsContext(item *container.ContainerInfo, noTrunc bool) psContext {
+	c := psContext{
+		ID:        item.Id,
+		Name:      item.Name,
+		Image:     item.Image,
+		Command:   item.Command,
+		CreatedAt: item.CreateTime,
+		Status:    item.Status,
+		Pid:       item.Pid,
+		ExitCode:  item.ExitCode,
+		Labels:    item.Labels,
+	}
+	if item.Status == container.EXIT {
+		c.Status = fmt.Sprintf("%s (%d)", item.Status, item.ExitCode)
+	}
+	if !noTrunc {
+		c.ID = container.ShortID(c.ID)
+		if len(c.Command) > psCommandLength {
+			c.Command = c.Command[:psCommandLength-3] + "..."
+		}
+	}
+	return c
+}
+
+// parse key=value filters, grouping the values given for the same key
+func parseFilters(pairs []string) (map[string][]string, error) {
+	filters := make(map[string][]string)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("filter %s is not in key=value format", pair)
+		}
+		known := false
+		for _, key := range filterKeys {
+			if kv[0] == key {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid filter %s, available: %s", kv[0], strings.Join(filterKeys, ", "))
+		}
+		filters[kv[0]] = append(filters[kv[0]], kv[1])
+	}
+	return filters, nil
+}
+
+// a container matches when, for every filter key,
+// it matches at least one of the values given for it
+func matchFilters(item *container.ContainerInfo, filters map[string][]string) bool {
+	for key, values := range filters {
+		matched := false
+		for _, value := range values {
+			if matchFilter(item, key, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func matchFilter(item *container.ContainerInfo, key, value string) bool {
+	switch key {
+	case "id":
+		return strings.HasPrefix(item.Id, value)
+	case "name":
+		return strings.Contains(item.Name, value)
+	case "status":
+		return item.Status == value
+	case "label":
+		// label=key or label=key=value
+		kv := strings.SplitN(value, "=", 2)
+		labelValue, ok := item.Labels[kv[0]]
+		if len(kv) == 1 {
+			return ok
+		}
+		return ok && labelValue == kv[1]
+	case "ancestor":
+		return item.Image == value || item.Image == value+":latest" || item.Image+":latest" == value
+	case "exited":
+		return item.Status == container.EXIT && strconv.Itoa(item.ExitCode) == value
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"ToyDocker/image"
+	"ToyDocker/registry"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// client for the registry at host, creds being USER:PASSWORD or empty
+func newRegistryClient(host, creds string) (*registry.Client, error) {
+	username, password := "", ""
+	if creds != "" {
+		kv := strings.SplitN(creds, ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("credentials must be USER:PASSWORD")
+		}
+		username, password = kv[0], kv[1]
+	}
+	insecure := registry.IsLocalhost(host) || contains(config.InsecureRegistries, host)
+	return registry.NewClient(host, insecure, username, password), nil
+}
+
+// fetch image ref from its registry, skipping the layers stored already
+func pullImage(ref, creds string) error {
+	parsed, err := image.ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	host, repository := registry.SplitName(parsed.Name)
+	client, err := newRegistryClient(host, creds)
+	if err != nil {
+		return err
+	}
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+
+	content, mediaType, err := client.GetManifest(repository, parsed.Tag)
+	if err != nil {
+		return err
+	}
+	switch mediaType {
+	case image.MediaTypeLayoutIndex, image.MediaTypeDockerManifestList:
+		var index image.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return fmt.Errorf("decode index of %s: %v", parsed, err)
+		}
+		chosen, err := choosePlatform(index)
+		if err != nil {
+			return err
+		}
+		if content, mediaType, err = client.GetManifest(repository, chosen.Digest); err != nil {
+			return err
+		}
+		if image.Digest(content) != chosen.Digest {
+			return fmt.Errorf("manifest of %s does not match digest %s", parsed, chosen.Digest)
+		}
+	case image.MediaTypeManifest, image.MediaTypeDockerManifest:
+	default:
+		return fmt.Errorf("unsupported manifest media type %s", mediaType)
+	}
+	var manifest image.Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return fmt.Errorf("decode manifest of %s: %v", parsed, err)
+	}
+
+	// an image with this config holds its layers already
+	if img, err := imageStore.Get(manifest.Config.Digest); err == nil {
+		if err := imageStore.Tag(parsed, img.ID); err != nil {
+			return err
+		}
+		fmt.Printf("Image is up to date for %s\n", parsed)
+		return nil
+	}
+	configBlob, err := client.GetBlob(repository, manifest.Config.Digest)
+	if err != nil {
+		return err
+	}
+	imageConfig, err := ioutil.ReadAll(configBlob)
+	configBlob.Close()
+	if err != nil {
+		return err
+	}
+	var img image.Image
+	if err := json.Unmarshal(imageConfig, &img); err != nil {
+		return fmt.Errorf("decode config of %s: %v", parsed, err)
+	}
+	if len(img.RootFS.DiffIDs) != len(manifest.Layers) {
+		return fmt.Errorf("image config lists %d layers, the manifest %d", len(img.RootFS.DiffIDs), len(manifest.Layers))
+	}
+
+	held, err := registerLayers(img.RootFS.DiffIDs, func(i int) (io.ReadCloser, error) {
+		descriptor := manifest.Layers[i]
+		fmt.Printf("Pulling layer %s\n", image.ShortID(descriptor.Digest))
+		blob, err := client.GetBlob(repository, descriptor.Digest)
+		if err != nil {
+			return nil, err
+		}
+		return newVerifiedBlob(blob)
+	})
+	if err != nil {
+		return err
+	}
+	if err := storeImageConfig(imageConfig, held, []string{parsed.String()}, "Pulled"); err != nil {
+		return err
+	}
+	fmt.Printf("Digest: %s\n", image.Digest(content))
+	return nil
+}
+
+// upload image ref to the registry its name points at, skipping blobs it has
+func pushImage(ref, creds string) error {
+	parsed, err := image.ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	host, repository := registry.SplitName(parsed.Name)
+	client, err := newRegistryClient(host, creds)
+	if err != nil {
+		return err
+	}
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return err
+	}
+	img, err := imageStore.Resolve(parsed.String())
+	if err != nil {
+		return err
+	}
+	imageConfig, err := imageStore.Config(img.ID)
+	if err != nil {
+		return err
+	}
+	manifest, layers, err := buildManifest(img, imageConfig)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range layers {
+		exists, err := client.BlobExists(repository, l.DiffID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			fmt.Printf("Layer %s already exists\n", image.ShortID(l.DiffID))
+			continue
+		}
+		diff, err := layerStore.TarStream(l.ChainID)
+		if err != nil {
+			return err
+		}
+		err = client.PutBlob(repository, l.DiffID, diff)
+		diff.Close()
+		if err != nil {
+			return fmt.Errorf("push layer %s: %v", image.ShortID(l.DiffID), err)
+		}
+		fmt.Printf("Pushed layer %s\n", image.ShortID(l.DiffID))
+	}
+	if exists, err := client.BlobExists(repository, img.ID); err != nil {
+		return err
+	} else if !exists {
+		if err := client.PutBlob(repository, img.ID, bytes.NewReader(imageConfig)); err != nil {
+			return fmt.Errorf("push config: %v", err)
+		}
+	}
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := client.PutManifest(repository, parsed.Tag, image.MediaTypeManifest, content); err != nil {
+		return err
+	}
+	fmt.Printf("%s: digest: %s size: %d\n", parsed.Tag, image.Digest(content), len(content))
+	return nil
+}
+
+// verifiedBlob reads a possibly gzipped layer blob, Close reads the rest of the blob
+// so the client checks its digest
+type verifiedBlob struct {
+	io.Reader
+	blob io.ReadCloser
+}
+
+func newVerifiedBlob(blob io.ReadCloser) (*verifiedBlob, error) {
+	reader, err := maybeGunzip(blob)
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+	return &verifiedBlob{Reader: reader, blob: blob}, nil
+}
+
+func (b *verifiedBlob) Close() error {
+	// whatever follows the compressed stream counts too
+	_, err := io.Copy(ioutil.Discard, b.blob)
+	b.blob.Close()
+	return err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"ToyDocker/container"
+	"github.com/sirupsen/logrus"
+	"strconv"
+)
+
+// report whether the recorded process is still the one we started:
+// it exists and has not exited waiting to be reaped, has the recorded
+// start time (so the pid was not reused) and sits in the recorded cgroup
+func processAlive(containerInfo *container.ContainerInfo) bool {
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil || pid <= 0 {
+		return false
+	}
+	if !container.ProcessExists(pid) {
+		return false
+	}
+	if zombie, err := container.ProcessZombie(pid); err == nil && zombie {
+		return false
+	}
+	if containerInfo.PidStartTime != 0 {
+		startTime, err := container.ProcessStartTime(pid)
+		if err != nil || startTime != containerInfo.PidStartTime {
+			return false
+		}
+	}
+	if containerInfo.CgroupPath != "" {
+		in, err := container.ProcessInCgroup(pid, containerInfo.CgroupPath)
+		if err != nil || !in {
+			return false
+		}
+	}
+	return true
+}
+
+// a container counts as running while its record says so and its process is still alive
+func containerRunning(containerID string) bool {
+	containerInfo, err := containerStore.Get(containerID)
+	if err != nil {
+		return false
+	}
+	return containerInfo.Status == container.RUNNING && processAlive(containerInfo)
+}
+
+// correct the record of a container that says running although its process
+// is gone, e.g. after a reboot or when toy-docker was killed. With cleanup set
+// the mounts left behind by the dead container are removed too.
+func reconcileContainer(containerInfo *container.ContainerInfo, cleanup bool) *container.ContainerInfo {
+	if containerInfo.Status != container.RUNNING || processAlive(containerInfo) {
+		return containerInfo
+	}
+	died := false
+	updated, err := containerStore.Update(containerInfo.Id, func(current *container.ContainerInfo) error {
+		// check again under the lock, the record may have changed meanwhile
+		if current.Status == container.RUNNING && !processAlive(current) {
+			current.Status = container.EXIT
+			// nobody saw it exit
+			current.ExitCode = -1
+			died = true
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Reconcile container %s error %v", containerInfo.Name, err)
+		return containerInfo
+	}
+	if died && cleanup {
+		cleanupContainerMounts(updated)
+	}
+	return updated
+}
+
+func reconcileContainers(containers []*container.ContainerInfo, cleanup bool) []*container.ContainerInfo {
+	for i, item := range containers {
+		containers[i] = reconcileContainer(item, cleanup)
+	}
+	return containers
+}
+
+// unmount the workspace of a dead container, its write layer stays until rm
+func cleanupContainerMounts(containerInfo *container.ContainerInfo) {
+	if containerInfo.GraphDriver.Name == "" {
+		return
+	}
+	driver, err := containerStorageDriver(containerInfo)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+	container.UnmountWorkSpace(driver, containerInfo.Id, containerInfo.Rootfs, containerInfo.Volume)
+}
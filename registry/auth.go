@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parse a WWW-Authenticate header like
+// Bearer realm="https://auth.example.com/token",service="registry",scope="repository:app:pull"
+func parseChallenge(header string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+	fields := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	scheme = strings.ToLower(fields[0])
+	if len(fields) < 2 {
+		return scheme, params
+	}
+	rest := fields[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				value, rest = rest, ""
+			} else {
+				value, rest = rest[:end], rest[end:]
+			}
+		}
+		params[key] = value
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+		rest = strings.TrimSpace(rest)
+	}
+	return scheme, params
+}
+
+// answer the challenge of a 401 response, setting c.authorization for the next requests
+func (c *Client) authenticate(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if c.username == "" {
+			return fmt.Errorf("registry %s needs credentials", c.host)
+		}
+		c.authorization = ""
+		c.basic = true
+		return nil
+	case "bearer":
+		realm := params["realm"]
+		if realm == "" {
+			return fmt.Errorf("bearer challenge of %s has no realm", c.host)
+		}
+		tokenURL, err := url.Parse(realm)
+		if err != nil {
+			return fmt.Errorf("invalid token realm %s: %v", realm, err)
+		}
+		query := tokenURL.Query()
+		if service := params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		if scope := params["scope"]; scope != "" {
+			query.Set("scope", scope)
+		}
+		tokenURL.RawQuery = query.Encode()
+		req, err := http.NewRequest("GET", tokenURL.String(), nil)
+		if err != nil {
+			return err
+		}
+		if c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("get token from %s: %v", realm, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("get token from %s: %s", realm, resp.Status)
+		}
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return fmt.Errorf("decode token: %v", err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		if token.Token == "" {
+			return fmt.Errorf("token server %s returned no token", realm)
+		}
+		c.authorization = "Bearer " + token.Token
+		return nil
+	}
+	return fmt.Errorf("unsupported authentication scheme %s of %s", scheme, c.host)
+}
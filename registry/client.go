@@ -0,0 +1,312 @@
+package registry
+
+import (
+	"ToyDocker/image"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uploads are sent in chunks of this size
+const chunkSize = 5 << 20
+
+const (
+	// time to connect to a registry or token server
+	dialTimeout = 30 * time.Second
+	// time a registry has to start answering a request, bodies may take longer
+	responseHeaderTimeout = 60 * time.Second
+)
+
+// manifest media types asked for when pulling
+var manifestTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+}
+
+// Client speaks the OCI distribution API to one registry
+type Client struct {
+	client *http.Client
+	// host[:port] of the registry
+	host   string
+	scheme string
+	// credentials, empty for anonymous access
+	username string
+	password string
+	// Authorization header a challenge was answered with
+	authorization string
+	basic         bool
+}
+
+// client for the registry at host, over http instead of https when insecure is set
+func NewClient(host string, insecure bool, username, password string) *Client {
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+	return &Client{
+		client:   newHTTPClient(),
+		host:     host,
+		scheme:   scheme,
+		username: username,
+		password: password,
+	}
+}
+
+// http client that gives up on registries that do not connect or answer,
+// without limiting how long a blob may take to stream
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = dialTimeout
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+	return &http.Client{Transport: transport}
+}
+
+func (c *Client) url(path string) string {
+	return c.scheme + "://" + c.host + "/v2/" + path
+}
+
+// send the request newRequest builds, answering an authentication challenge once.
+// newRequest is called again for the retry, so bodies can be sent twice.
+func (c *Client) do(newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		if c.basic {
+			req.SetBasicAuth(c.username, c.password)
+		} else if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if challenge == "" {
+			return nil, fmt.Errorf("%s %s: unauthorized", req.Method, req.URL)
+		}
+		if err := c.authenticate(challenge); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// error for an unexpected response, with what the registry said about it
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s %s: %s %s", resp.Request.Method, resp.Request.URL, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// fetch the manifest of repository at reference, a tag or digest, and return it with its media type.
+// The digest the registry sends along is checked, the caller checks the one it asked for.
+func (c *Client) GetManifest(repository, reference string) ([]byte, string, error) {
+	resp, err := c.do(func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", c.url(repository+"/manifests/"+reference), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+		return req, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError(resp)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" && digest != image.Digest(content) {
+		return nil, "", fmt.Errorf("manifest of %s:%s does not match digest %s", repository, reference, digest)
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.Index(mediaType, ";"); i >= 0 {
+		mediaType = mediaType[:i]
+	}
+	return content, strings.TrimSpace(mediaType), nil
+}
+
+// upload a manifest as repository:reference
+func (c *Client) PutManifest(repository, reference, mediaType string, content []byte) error {
+	resp, err := c.do(func() (*http.Request, error) {
+		req, err := http.NewRequest("PUT", c.url(repository+"/manifests/"+reference), bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mediaType)
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// stream the blob with digest, reading it to the end fails when the content does not match digest
+func (c *Client) GetBlob(repository, digest string) (io.ReadCloser, error) {
+	resp, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest("GET", c.url(repository+"/blobs/"+digest), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return &verifiedReader{body: resp.Body, digester: sha256.New(), digest: digest}, nil
+}
+
+// verifiedReader returns an error instead of io.EOF at the end of a blob that does not match digest
+type verifiedReader struct {
+	body     io.ReadCloser
+	digester hash.Hash
+	digest   string
+}
+
+func (r *verifiedReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.digester.Write(p[:n])
+	if err == io.EOF {
+		if actual := "sha256:" + hex.EncodeToString(r.digester.Sum(nil)); actual != r.digest {
+			return n, fmt.Errorf("blob does not match digest %s", r.digest)
+		}
+	}
+	return n, err
+}
+
+func (r *verifiedReader) Close() error {
+	return r.body.Close()
+}
+
+// report whether the registry already has the blob with digest
+func (c *Client) BlobExists(repository, digest string) (bool, error) {
+	resp, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest("HEAD", c.url(repository+"/blobs/"+digest), nil)
+	})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, responseError(resp)
+}
+
+// upload blob content with digest in chunks
+func (c *Client) PutBlob(repository, digest string, content io.Reader) error {
+	resp, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest("POST", c.url(repository+"/blobs/uploads/"), nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return responseError(resp)
+	}
+	location, err := c.location(resp)
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]byte, chunkSize)
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(content, chunk)
+		if n > 0 {
+			data := chunk[:n]
+			resp, err := c.do(func() (*http.Request, error) {
+				req, err := http.NewRequest("PATCH", location, bytes.NewReader(data))
+				if err != nil {
+					return nil, err
+				}
+				req.Header.Set("Content-Type", "application/octet-stream")
+				req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(n)-1))
+				req.Header.Set("Content-Length", strconv.Itoa(n))
+				return req, nil
+			})
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+				return responseError(resp)
+			}
+			if location, err = c.location(resp); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	resp, err = c.do(func() (*http.Request, error) {
+		finish, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		query := finish.Query()
+		query.Set("digest", digest)
+		finish.RawQuery = query.Encode()
+		return http.NewRequest("PUT", finish.String(), nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// the upload location a response points at, made absolute
+func (c *Client) location(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("%s %s: no upload location in response", resp.Request.Method, resp.Request.URL)
+	}
+	resolved, err := resp.Request.URL.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid upload location %s: %v", location, err)
+	}
+	return resolved.String(), nil
+}
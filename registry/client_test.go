@@ -0,0 +1,251 @@
+package registry
+
+import (
+	"ToyDocker/image"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testToken = "t0k3n"
+
+// fakeRegistry is an in-memory registry for repository app behind a bearer token server
+type fakeRegistry struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	uploads   map[string]*bytes.Buffer
+	// requests seen, by kind
+	tokenRequests int
+	patches       int
+	// scope and service the last token request asked for
+	tokenQuery string
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{
+		manifests: make(map[string][]byte),
+		blobs:     make(map[string][]byte),
+		uploads:   make(map[string]*bytes.Buffer),
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *fakeRegistry) client() *Client {
+	return NewClient(strings.TrimPrefix(r.server.URL, "http://"), true, "", "")
+}
+
+func (r *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.URL.Path == "/token" {
+		r.tokenRequests++
+		r.tokenQuery = req.URL.RawQuery
+		fmt.Fprintf(w, `{"token": %q}`, testToken)
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+testToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake",scope="repository:app:pull,push"`, r.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v2/app/")
+	switch {
+	case strings.HasPrefix(path, "manifests/"):
+		reference := strings.TrimPrefix(path, "manifests/")
+		switch req.Method {
+		case "GET":
+			content, ok := r.manifests[reference]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", image.MediaTypeManifest+"; charset=utf-8")
+			w.Header().Set("Docker-Content-Digest", image.Digest(content))
+			w.Write(content)
+		case "PUT":
+			content, _ := ioutil.ReadAll(req.Body)
+			r.manifests[reference] = content
+			r.manifests[image.Digest(content)] = content
+			w.WriteHeader(http.StatusCreated)
+		}
+	case path == "blobs/uploads/" && req.Method == "POST":
+		id := fmt.Sprintf("upload-%d", len(r.uploads))
+		r.uploads[id] = &bytes.Buffer{}
+		w.Header().Set("Location", "/v2/app/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(path, "blobs/uploads/"):
+		id := strings.TrimPrefix(path, "blobs/uploads/")
+		upload, ok := r.uploads[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch req.Method {
+		case "PATCH":
+			r.patches++
+			if want := fmt.Sprintf("%d-", upload.Len()); !strings.HasPrefix(req.Header.Get("Content-Range"), want) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			data, _ := ioutil.ReadAll(req.Body)
+			upload.Write(data)
+			w.Header().Set("Location", "/v2/app/blobs/uploads/"+id)
+			w.WriteHeader(http.StatusAccepted)
+		case "PUT":
+			digest := req.URL.Query().Get("digest")
+			if image.Digest(upload.Bytes()) != digest {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, "DIGEST_INVALID")
+				return
+			}
+			r.blobs[digest] = upload.Bytes()
+			delete(r.uploads, id)
+			w.WriteHeader(http.StatusCreated)
+		}
+	case strings.HasPrefix(path, "blobs/"):
+		content, ok := r.blobs[strings.TrimPrefix(path, "blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if req.Method == "GET" {
+			w.Write(content)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBearerChallenge(t *testing.T) {
+	registry := newFakeRegistry(t)
+	client := registry.client()
+	if _, err := client.BlobExists("app", image.Digest([]byte("a"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.BlobExists("app", image.Digest([]byte("b"))); err != nil {
+		t.Fatal(err)
+	}
+	if registry.tokenRequests != 1 {
+		t.Errorf("token requested %d times, want once", registry.tokenRequests)
+	}
+	for _, want := range []string{"service=fake", "scope=repository%3Aapp%3Apull%2Cpush"} {
+		if !strings.Contains(registry.tokenQuery, want) {
+			t.Errorf("token query %s lacks %s", registry.tokenQuery, want)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		header string
+		scheme string
+		params map[string]string
+	}{
+		{`Basic realm="registry"`, "basic", map[string]string{"realm": "registry"}},
+		{
+			`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:app:pull"`,
+			"bearer",
+			map[string]string{"realm": "https://auth.example.com/token", "service": "registry", "scope": "repository:app:pull"},
+		},
+		{`Bearer realm=https://auth.example.com/token, service=registry`, "bearer", map[string]string{"realm": "https://auth.example.com/token", "service": "registry"}},
+	}
+	for _, test := range tests {
+		scheme, params := parseChallenge(test.header)
+		if scheme != test.scheme {
+			t.Errorf("%s: scheme %s, want %s", test.header, scheme, test.scheme)
+		}
+		if fmt.Sprint(params) != fmt.Sprint(test.params) {
+			t.Errorf("%s: params %v, want %v", test.header, params, test.params)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	registry := newFakeRegistry(t)
+	client := registry.client()
+	content := []byte(`{"schemaVersion": 2}`)
+	if err := client.PutManifest("app", "1.0", image.MediaTypeManifest, content); err != nil {
+		t.Fatal(err)
+	}
+	for _, reference := range []string{"1.0", image.Digest(content)} {
+		got, mediaType, err := client.GetManifest("app", reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("manifest %s is %s, want %s", reference, got, content)
+		}
+		if mediaType != image.MediaTypeManifest {
+			t.Errorf("media type %s, want %s", mediaType, image.MediaTypeManifest)
+		}
+	}
+	if _, _, err := client.GetManifest("app", "missing"); err == nil {
+		t.Error("got a manifest that does not exist")
+	}
+}
+
+func TestBlobChunkedUpload(t *testing.T) {
+	registry := newFakeRegistry(t)
+	client := registry.client()
+	content := bytes.Repeat([]byte("layer"), chunkSize/5+1000)
+	digest := image.Digest(content)
+
+	exists, err := client.BlobExists("app", digest)
+	if err != nil || exists {
+		t.Fatalf("blob exists before upload: %v %v", exists, err)
+	}
+	if err := client.PutBlob("app", digest, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if registry.patches != 2 {
+		t.Errorf("uploaded in %d chunks, want 2", registry.patches)
+	}
+	exists, err = client.BlobExists("app", digest)
+	if err != nil || !exists {
+		t.Fatalf("blob missing after upload: %v %v", exists, err)
+	}
+	blob, err := client.GetBlob("app", digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("blob read back differs from the one uploaded")
+	}
+}
+
+func TestBlobDigestMismatch(t *testing.T) {
+	registry := newFakeRegistry(t)
+	client := registry.client()
+
+	// the registry refuses an upload that does not match its digest
+	if err := client.PutBlob("app", image.Digest([]byte("other")), strings.NewReader("content")); err == nil {
+		t.Error("upload with a wrong digest succeeded")
+	}
+
+	// and the client refuses a blob that does not match the digest asked for
+	digest := image.Digest([]byte("content"))
+	registry.blobs[digest] = []byte("tampered")
+	blob, err := client.GetBlob("app", digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	if _, err := ioutil.ReadAll(blob); err == nil || !strings.Contains(err.Error(), "does not match digest") {
+		t.Errorf("reading a tampered blob gave %v, want a digest mismatch", err)
+	}
+}
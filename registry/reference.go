@@ -0,0 +1,35 @@
+// Package registry talks to registries speaking the OCI distribution API
+package registry
+
+import "strings"
+
+const (
+	// registry of names without a host, and the namespace of its single component names
+	DefaultHost      = "registry-1.docker.io"
+	defaultNamespace = "library/"
+)
+
+// split an image name into the registry host and the repository on it.
+// The first component is a host when it has a dot or port, or is localhost.
+func SplitName(name string) (host, repository string) {
+	i := strings.Index(name, "/")
+	if i > 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			return first, name[i+1:]
+		}
+	}
+	if !strings.Contains(name, "/") {
+		return DefaultHost, defaultNamespace + name
+	}
+	return DefaultHost, name
+}
+
+// report whether host is on this machine, those are spoken to over plain http
+func IsLocalhost(host string) bool {
+	hostname := host
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		hostname = host[:i]
+	}
+	return hostname == "localhost" || hostname == "127.0.0.1" || hostname == "[::1]"
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"ToyDocker/cgroups"
+	"ToyDocker/container"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// how long rm -f waits for a killed container process to exit
+const killTimeout = 10 * time.Second
+
+// remove the named containers and the ones matching filters.
+// Running containers are refused unless force is set, then they are killed first.
+func removeContainers(refs []string, filters map[string][]string, force bool) error {
+	var targets []*container.ContainerInfo
+	seen := make(map[string]bool)
+	failed := 0
+	for _, ref := range refs {
+		containerInfo, err := containerStore.Resolve(ref)
+		if err != nil {
+			logrus.Errorf("%v", err)
+			failed++
+			continue
+		}
+		if !seen[containerInfo.Id] {
+			seen[containerInfo.Id] = true
+			targets = append(targets, containerInfo)
+		}
+	}
+	if len(filters) > 0 {
+		containers, err := containerStore.List()
+		if err != nil {
+			return fmt.Errorf("List containers error %v", err)
+		}
+		for _, item := range containers {
+			if !seen[item.Id] && matchFilters(item, filters) {
+				seen[item.Id] = true
+				targets = append(targets, item)
+			}
+		}
+	}
+
+	for _, containerInfo := range targets {
+		if err := removeContainer(containerInfo, force); err != nil {
+			logrus.Errorf("Remove container %s error %v", containerInfo.Name, err)
+			failed++
+			continue
+		}
+		fmt.Println(containerInfo.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d containers", failed)
+	}
+	return nil
+}
+
+// remove every exited or stopped container matching filters. Created ones
+// are left alone, a run may still be setting them up.
+func pruneContainers(filters map[string][]string) error {
+	containers, err := containerStore.List()
+	if err != nil {
+		return fmt.Errorf("List containers error %v", err)
+	}
+	// records of containers that died unnoticed say exited from here on
+	for _, item := range reconcileContainers(containers, true) {
+		if item.Status != container.EXIT && item.Status != container.STOP {
+			continue
+		}
+		if !matchFilters(item, filters) {
+			continue
+		}
+		if err := removeContainer(item, false); err != nil {
+			logrus.Errorf("Remove container %s error %v", item.Name, err)
+			continue
+		}
+		fmt.Println(item.Name)
+	}
+	return nil
+}
+
+func removeContainer(containerInfo *container.ContainerInfo, force bool) error {
+	if containerRunning(containerInfo.Id) {
+		if !force {
+			return fmt.Errorf("container is running, stop it first or use rm -f")
+		}
+		pid, err := strconv.Atoi(containerInfo.Pid)
+		if err != nil {
+			return fmt.Errorf("invalid pid %s", containerInfo.Pid)
+		}
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("kill container process %d: %v", pid, err)
+		}
+		// the process keeps the rootfs busy until it is gone
+		if err := waitProcessExit(containerInfo, pid); err != nil {
+			return err
+		}
+	}
+	// a detached container leaves its cgroup behind when it exits
+	if containerInfo.CgroupPath != "" {
+		cgroups.NewCgroupManager(containerInfo.CgroupPath).Destroy()
+	}
+	// records from before storage drivers have no write layer
+	if containerInfo.GraphDriver.Name != "" {
+		driver, err := containerStorageDriver(containerInfo)
+		if err != nil {
+			return err
+		}
+		container.UnmountWorkSpace(driver, containerInfo.Id, containerInfo.Rootfs, containerInfo.Volume)
+		if err := container.DeleteWorkSpace(driver, containerInfo.Id); err != nil {
+			return err
+		}
+	}
+	return containerStore.Delete(containerInfo.Id)
+}
+
+// wait until the recorded process has exited. processAlive checks the start
+// time, so a pid reused meanwhile does not count, and takes a zombie as exited.
+func waitProcessExit(containerInfo *container.ContainerInfo, pid int) error {
+	deadline := time.Now().Add(killTimeout)
+	for processAlive(containerInfo) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container process %d did not exit within %v", pid, killTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
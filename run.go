@@ -4,17 +4,119 @@ import (
 	"ToyDocker/cgroups"
 	"ToyDocker/cgroups/subsystems"
 	"ToyDocker/container"
+	"ToyDocker/image"
+	"encoding/json"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func Run(tty bool, cmdArray []string, resource *subsystems.ResourceConfig, volume, containerName string) {
-	parent, writePipe := container.NewParentProcess(tty, containerName, volume)
+// entrypoint replaces the one of the image unless nil, expose adds to its exposed ports
+func Run(tty bool, imageRef string, process container.ProcessConfig, entrypoint, expose []string, resource *subsystems.ResourceConfig, volume, containerName, logDriver string, logOpts, labels map[string]string) {
+	img, err := resolveImage(imageRef)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+	process, err = imageProcess(img.Config, process, entrypoint)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+	exposedPorts, err := imagePorts(img.Config, expose)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+	labels = imageLabels(img.Config, labels)
+	imageLayer, err := topLayer(img)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+	// record the tag the image was asked for by, ancestor filters match on it
+	if parsed, err := image.ParseReference(imageRef); err == nil {
+		imageRef = parsed.String()
+	}
+	containerID, err := container.GenerateID()
+	if err != nil {
+		logrus.Errorf("Generate container id error %v", err)
+		return
+	}
+	// record the container before starting it, this also takes its name
+	containerInfo := &container.ContainerInfo{
+		Id:           containerID,
+		Name:         containerName,
+		Command:      strings.Join(process.Args, " "),
+		CreateTime:   time.Now().Format("2006-01-02 15:04:05"),
+		Status:       container.CREATED,
+		Volume:       volume,
+		ExposedPorts: exposedPorts,
+		Image:        imageRef,
+		ImageID:      img.ID,
+		Labels:       labels,
+		LogDriver:    logDriver,
+		LogOpts:      logOpts,
+	}
+	if err := containerStore.Create(containerInfo); err != nil {
+		logrus.Errorf("Record container info error %v", err)
+		return
+	}
+
+	driver, err := getStorageDriver()
+	if err != nil {
+		logrus.Errorf("%v", err)
+		containerStore.Delete(containerID)
+		return
+	}
+	mntUrl, err := container.NewWorkSpace(driver, containerID, imageLayer.CacheID, volume)
+	if err != nil {
+		logrus.Errorf("New workspace error %v", err)
+		containerStore.Delete(containerID)
+		return
+	}
+	// give up on the container before it ever ran
+	discard := func() {
+		container.UnmountWorkSpace(driver, containerID, mntUrl, volume)
+		if err := container.DeleteWorkSpace(driver, containerID); err != nil {
+			logrus.Errorf("%v", err)
+		}
+		containerStore.Delete(containerID)
+	}
+	metadata, err := driver.Metadata(containerID)
+	if err != nil {
+		logrus.Errorf("Read write layer metadata error %v", err)
+	}
+	if _, err := containerStore.Update(containerID, func(info *container.ContainerInfo) error {
+		info.GraphDriver = container.GraphDriverData{Name: driver.String(), Data: metadata}
+		info.Rootfs = mntUrl
+		return nil
+	}); err != nil {
+		logrus.Errorf("Record container info error %v", err)
+		discard()
+		return
+	}
+	parent, writePipe := container.NewParentProcess(tty, mntUrl)
 	if parent == nil {
 		logrus.Errorf("failed to new parent process")
+		discard()
 		return
 	}
+	// without a tty the output goes through pipes to the logger process
+	var logPipes []*os.File
+	if !tty {
+		logPipes, err = wireLogPipes(parent)
+		if err != nil {
+			logrus.Errorf("create log pipes error %v", err)
+			discard()
+			return
+		}
+	}
 	// Start(): It will first clone the name space isolated process,
 	// and then call /proc/self/exe in the child process,
 	// sending the init parameter to call the init method to initialize
@@ -22,43 +124,141 @@ func Run(tty bool, cmdArray []string, resource *subsystems.ResourceConfig, volum
 
 	if err := parent.Start(); err != nil {
 		logrus.Errorf("parent start failed, err: %v", err)
+		discard()
 		return
 	}
 
+	// add resource limit, removed when a tty container exits and by rm otherwise
+	cgroupManager := cgroups.NewCgroupManager(path.Join("toy-docker", containerID))
+
+	// setup resource limit
+	cgroupManager.Set(resource)
+
+	// apply
+	cgroupErr := cgroupManager.Apply(parent.Process.Pid)
+	if cgroupErr != nil {
+		logrus.Errorf("Apply cgroup error %v", cgroupErr)
+	}
+
 	// log container info
-	containerName, err := recordContainerInfo(parent.Process.Pid, cmdArray, containerName)
+	startTime, err := container.ProcessStartTime(parent.Process.Pid)
 	if err != nil {
+		logrus.Errorf("Read container process start time error %v", err)
+	}
+	if _, err := containerStore.Update(containerID, func(info *container.ContainerInfo) error {
+		info.Pid = strconv.Itoa(parent.Process.Pid)
+		info.PidStartTime = startTime
+		// only recorded when the process really is in it, reconciliation relies on it
+		if cgroupErr == nil {
+			info.CgroupPath = cgroupManager.Path
+		}
+		info.Status = container.RUNNING
+		return nil
+	}); err != nil {
 		logrus.Errorf("Record container info error %v", err)
+		// nothing would know about the process, take it down with the rest
+		parent.Process.Kill()
+		parent.Wait()
+		cgroupManager.Destroy()
+		discard()
 		return
 	}
 
-	// add resource limit
-	cgroupManager := cgroups.NewCgroupManager("toy-docker")
-
-	// delete resource limit
-	defer cgroupManager.Destroy()
-
-	// setup resource limit
-	cgroupManager.Set(resource)
-
-	// apply
-	cgroupManager.Apply(parent.Process.Pid)
+	if !tty {
+		if err := startLogger(containerID, logPipes); err != nil {
+			logrus.Errorf("Start logger error %v", err)
+		}
+	}
 
 	// init contianer send cmd
-	sendInitCommand(cmdArray, writePipe)
+	sendInitCommand(process, writePipe)
 	if tty {
 		parent.Wait()
-		deleteContainerInfo(containerName)
+		// keep the record so that ps -a still shows the container
+		if _, err := containerStore.Update(containerID, func(info *container.ContainerInfo) error {
+			info.Status = container.EXIT
+			info.ExitCode = parent.ProcessState.ExitCode()
+			return nil
+		}); err != nil {
+			logrus.Errorf("Record container exit error %v", err)
+		}
+	}
+	// the write layer stays until rm, so the container can still be committed
+	if tty {
+		container.UnmountWorkSpace(driver, containerID, mntUrl, volume)
+		cgroupManager.Destroy()
 	}
-	mntUrl := "/root/mnt"
-	rootUrl := "/root"
-	container.DeleteWorkSpace(rootUrl, mntUrl, volume)
 	os.Exit(0)
 }
 
-func sendInitCommand(cmdArray []string, writePipe *os.File) {
-	cmd := strings.Join(cmdArray, " ")
-	logrus.Infof("command all is %s", cmd)
-	writePipe.WriteString(cmd)
+func sendInitCommand(process container.ProcessConfig, writePipe *os.File) {
+	logrus.Infof("command all is %s", strings.Join(process.Args, " "))
+	msg, err := json.Marshal(process)
+	if err != nil {
+		logrus.Errorf("Encode process error %v", err)
+	}
+	writePipe.Write(msg)
 	writePipe.Close()
 }
+
+// the process a container of an image runs: the defaults of the image config,
+// overridden by what run was given. Arguments replace Cmd, a non-nil entrypoint
+// replaces Entrypoint and drops Cmd, -e values are set on top of Env.
+func imageProcess(config *image.Config, process container.ProcessConfig, entrypoint []string) (container.ProcessConfig, error) {
+	config = config.Copy()
+	if entrypoint != nil {
+		config.Entrypoint = entrypoint
+		config.Cmd = nil
+	}
+	args := config.Cmd
+	if len(process.Args) > 0 {
+		args = process.Args
+	}
+	process.Args = append(append([]string{}, config.Entrypoint...), args...)
+	if len(process.Args) == 0 {
+		return process, fmt.Errorf("no command given and the image has neither Entrypoint nor Cmd")
+	}
+	env := config.Env
+	for _, pair := range process.Env {
+		kv := strings.SplitN(pair, "=", 2)
+		env = image.SetEnv(env, kv[0], kv[1])
+	}
+	process.Env = env
+	if process.Dir == "" {
+		process.Dir = config.WorkingDir
+	}
+	if process.User == "" {
+		process.User = config.User
+	}
+	return process, nil
+}
+
+// the labels of the image config with labels laid over them, the given ones win
+func imageLabels(config *image.Config, labels map[string]string) map[string]string {
+	merged := make(map[string]string)
+	if config != nil {
+		for key, value := range config.Labels {
+			merged[key] = value
+		}
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	return merged
+}
+
+// the ports of the image config and expose, as port/protocol, sorted
+func imagePorts(config *image.Config, expose []string) ([]string, error) {
+	config = config.Copy()
+	if len(expose) > 0 {
+		if err := image.ApplyChange(config, "EXPOSE "+strings.Join(expose, " ")); err != nil {
+			return nil, err
+		}
+	}
+	var ports []string
+	for port := range config.ExposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	return ports, nil
+}
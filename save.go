@@ -0,0 +1,208 @@
+package main
+
+import (
+	"ToyDocker/image"
+	"ToyDocker/layer"
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// write the images refs name as an OCI image layout tar to output, stdout when empty.
+// A docker save style manifest.json is included, so docker load reads it too.
+func saveImages(refs []string, output string) error {
+	imageStore, err := getImageStore()
+	if err != nil {
+		return err
+	}
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return err
+	}
+	// the images in the order given, with the tags they were asked for by
+	var images []*image.Image
+	tags := make(map[string][]string)
+	for _, ref := range refs {
+		img, err := imageStore.Resolve(ref)
+		if err != nil {
+			return err
+		}
+		if _, ok := tags[img.ID]; !ok {
+			images = append(images, img)
+			tags[img.ID] = nil
+		}
+		// saved by id the image has no name
+		if parsed, err := image.ParseReference(ref); err == nil {
+			if refTags, _ := imageStore.Tags(img.ID); contains(refTags, parsed.String()) {
+				tags[img.ID] = append(tags[img.ID], parsed.String())
+			}
+		}
+	}
+
+	out := os.Stdout
+	if output != "" {
+		if out, err = os.Create(output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	w := &layoutWriter{tw: tar.NewWriter(out), written: make(map[string]bool)}
+	index := image.Index{SchemaVersion: 2, MediaType: image.MediaTypeLayoutIndex}
+	var legacy []image.LegacyManifest
+	for _, img := range images {
+		imageConfig, err := imageStore.Config(img.ID)
+		if err != nil {
+			return err
+		}
+		if err := w.writeBlob(img.ID, int64(len(imageConfig)), bytes.NewReader(imageConfig)); err != nil {
+			return err
+		}
+		manifest, layers, err := buildManifest(img, imageConfig)
+		if err != nil {
+			return err
+		}
+		legacyManifest := image.LegacyManifest{Config: blobPath(img.ID), RepoTags: tags[img.ID]}
+		for _, l := range layers {
+			if err := w.writeLayer(layerStore, l); err != nil {
+				return err
+			}
+			legacyManifest.Layers = append(legacyManifest.Layers, blobPath(l.DiffID))
+		}
+		manifestContent, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		manifestDigest := image.Digest(manifestContent)
+		if err := w.writeBlob(manifestDigest, int64(len(manifestContent)), bytes.NewReader(manifestContent)); err != nil {
+			return err
+		}
+		descriptor := image.Descriptor{MediaType: image.MediaTypeManifest, Digest: manifestDigest, Size: int64(len(manifestContent))}
+		if len(tags[img.ID]) == 0 {
+			index.Manifests = append(index.Manifests, descriptor)
+		}
+		for _, tag := range tags[img.ID] {
+			parsed, _ := image.ParseReference(tag)
+			descriptor.Annotations = map[string]string{
+				image.AnnotationImageName: parsed.String(),
+				image.AnnotationRefName:   parsed.Tag,
+			}
+			index.Manifests = append(index.Manifests, descriptor)
+		}
+		legacy = append(legacy, legacyManifest)
+	}
+
+	if err := w.writeJSON("oci-layout", image.Layout{Version: "1.0.0"}); err != nil {
+		return err
+	}
+	if err := w.writeJSON("index.json", index); err != nil {
+		return err
+	}
+	if err := w.writeJSON("manifest.json", legacy); err != nil {
+		return err
+	}
+	return w.tw.Close()
+}
+
+// OCI manifest of img with the given config, and its layers bottom up.
+// Layers go as the uncompressed tar diffs, so their digests are their DiffIDs.
+func buildManifest(img *image.Image, imageConfig []byte) (image.Manifest, []*layer.Layer, error) {
+	manifest := image.Manifest{
+		SchemaVersion: 2,
+		MediaType:     image.MediaTypeManifest,
+		Config:        image.Descriptor{MediaType: image.MediaTypeConfig, Digest: img.ID, Size: int64(len(imageConfig))},
+	}
+	layerStore, err := getLayerStore()
+	if err != nil {
+		return manifest, nil, err
+	}
+	var layers []*layer.Layer
+	chainID := ""
+	for _, diffID := range img.RootFS.DiffIDs {
+		chainID = layer.ChainID(chainID, diffID)
+		l, err := layerStore.Get(chainID)
+		if err != nil {
+			return manifest, nil, err
+		}
+		layers = append(layers, l)
+		manifest.Layers = append(manifest.Layers, image.Descriptor{MediaType: image.MediaTypeLayer, Digest: l.DiffID, Size: l.Size})
+	}
+	return manifest, layers, nil
+}
+
+// path of the blob with digest inside a layout
+func blobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// layoutWriter writes an image layout as a tar, every blob once
+type layoutWriter struct {
+	tw      *tar.Writer
+	written map[string]bool
+}
+
+func (w *layoutWriter) writeFile(name string, size int64, content io.Reader) error {
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(w.tw, content)
+	return err
+}
+
+func (w *layoutWriter) writeBlob(digest string, size int64, content io.Reader) error {
+	if w.written[digest] {
+		return nil
+	}
+	if len(w.written) == 0 {
+		for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+			if err := w.tw.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(0, 0)}); err != nil {
+				return err
+			}
+		}
+	}
+	w.written[digest] = true
+	return w.writeFile(blobPath(digest), size, content)
+}
+
+// the layer goes in as the tar diff it was registered from, which hashes to its DiffID
+func (w *layoutWriter) writeLayer(layerStore *layer.Store, l *layer.Layer) error {
+	if w.written[l.DiffID] {
+		return nil
+	}
+	diff, err := layerStore.TarStream(l.ChainID)
+	if err != nil {
+		return err
+	}
+	defer diff.Close()
+	if err := w.writeBlob(l.DiffID, l.Size, diff); err != nil {
+		return fmt.Errorf("write layer %s: %v", l.DiffID, err)
+	}
+	return nil
+}
+
+func (w *layoutWriter) writeJSON(name string, value interface{}) error {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return w.writeFile(name, int64(len(content)), bytes.NewReader(content))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"ToyDocker/container"
+	"ToyDocker/graphdriver"
+	"ToyDocker/image"
+	"ToyDocker/layer"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"path/filepath"
+)
+
+var storageDriver graphdriver.Driver
+
+var imageStore *image.Store
+
+var layerStore *layer.Store
+
+// drivers set up for containers created with another driver, by name
+var containerDrivers = make(map[string]graphdriver.Driver)
+
+// the storage driver, set up on first use:
+// init runs inside the container and must not touch the layers
+func getStorageDriver() (graphdriver.Driver, error) {
+	if storageDriver != nil {
+		return storageDriver, nil
+	}
+	driver, err := graphdriver.New(config.StorageDriver, config.Root)
+	if err != nil {
+		return nil, fmt.Errorf("storage driver: %v", err)
+	}
+	storageDriver = driver
+	return storageDriver, nil
+}
+
+// the storage driver holding the write layer of a container
+func containerStorageDriver(containerInfo *container.ContainerInfo) (graphdriver.Driver, error) {
+	name := containerInfo.GraphDriver.Name
+	if name == "" {
+		return nil, fmt.Errorf("container %s has no write layer", containerInfo.Name)
+	}
+	if storageDriver != nil && storageDriver.String() == name {
+		return storageDriver, nil
+	}
+	if driver, ok := containerDrivers[name]; ok {
+		return driver, nil
+	}
+	driver, err := graphdriver.New(name, config.Root)
+	if err != nil {
+		return nil, fmt.Errorf("storage driver: %v", err)
+	}
+	containerDrivers[name] = driver
+	return driver, nil
+}
+
+// mount the rootfs of a container, running or not, and return where it is with a function releasing it.
+// A running container has its rootfs mounted already, it stays mounted.
+func mountContainer(containerInfo *container.ContainerInfo) (string, func(), error) {
+	driver, err := containerStorageDriver(containerInfo)
+	if err != nil {
+		return "", nil, err
+	}
+	mounted := graphdriver.Mounted(containerInfo.Rootfs)
+	rootfs, err := driver.Get(containerInfo.Id)
+	if err != nil {
+		return "", nil, fmt.Errorf("mount container %s: %v", containerInfo.Name, err)
+	}
+	release := func() {
+		if mounted {
+			return
+		}
+		if err := driver.Put(containerInfo.Id); err != nil {
+			logrus.Errorf("Unmount container %s error %v", containerInfo.Name, err)
+		}
+	}
+	return rootfs, release, nil
+}
+
+// the storage driver of a container, its image and the image layer its write layer sits on.
+// The image layers live with the storage driver in use, the container must use that one.
+func containerImageLayer(containerInfo *container.ContainerInfo) (graphdriver.Driver, *image.Image, *layer.Layer, error) {
+	driver, err := containerStorageDriver(containerInfo)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	current, err := getStorageDriver()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if driver.String() != current.String() {
+		return nil, nil, nil, fmt.Errorf("container uses storage driver %s, but %s is in use", driver, current)
+	}
+	imageStore, err := getImageStore()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	img, err := imageStore.Get(containerInfo.ImageID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	imageLayer, err := topLayer(img)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return driver, img, imageLayer, nil
+}
+
+// images built on the layers of the storage driver, in <root>/image/<driver>
+func getImageStore() (*image.Store, error) {
+	if imageStore != nil {
+		return imageStore, nil
+	}
+	driver, err := getStorageDriver()
+	if err != nil {
+		return nil, err
+	}
+	imageStore = image.NewStore(filepath.Join(config.Root, "image", driver.String()))
+	return imageStore, nil
+}
+
+// image layers of the storage driver, in <root>/image/<driver>/layerdb
+func getLayerStore() (*layer.Store, error) {
+	if layerStore != nil {
+		return layerStore, nil
+	}
+	driver, err := getStorageDriver()
+	if err != nil {
+		return nil, err
+	}
+	layerStore = layer.NewStore(filepath.Join(config.Root, "image", driver.String(), "layerdb"), driver)
+	return layerStore, nil
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"ToyDocker/container"
+	"ToyDocker/fsutil"
+	"ToyDocker/logdriver"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// lock taken while records of the legacy layout are moved
+const migrateLockName = "migrate.lock"
+
+// version of the config.json layout written by this build
+const SchemaVersion = 1
+
+// migrations[i] upgrades a raw record from version i to i+1
+var migrations = []func(record map[string]interface{}) error{
+	migrateV0,
+}
+
+// decode config.json, upgrading records written by older versions
+func decode(content []byte) (*container.ContainerInfo, error) {
+	var record map[string]interface{}
+	if err := json.Unmarshal(content, &record); err != nil {
+		return nil, err
+	}
+	version := 0
+	if v, ok := record["schemaVersion"].(float64); ok {
+		version = int(v)
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("record has schema version %d, this build only knows up to %d", version, SchemaVersion)
+	}
+	for ; version < SchemaVersion; version++ {
+		if err := migrations[version](record); err != nil {
+			return nil, fmt.Errorf("migrate record from schema version %d: %v", version, err)
+		}
+	}
+	record["schemaVersion"] = SchemaVersion
+
+	migrated, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var containerInfo container.ContainerInfo
+	if err := json.Unmarshal(migrated, &containerInfo); err != nil {
+		return nil, err
+	}
+	return &containerInfo, nil
+}
+
+// version 0: records written before the store existed,
+// without a log driver and possibly without a name
+func migrateV0(record map[string]interface{}) error {
+	if driver, _ := record["logDriver"].(string); driver == "" {
+		record["logDriver"] = logdriver.DefaultDriver
+	}
+	if name, _ := record["name"].(string); name == "" {
+		id, ok := record["id"].(string)
+		if !ok || id == "" {
+			return fmt.Errorf("record has neither name nor id")
+		}
+		record["name"] = id
+	}
+	return nil
+}
+
+// MigrateLegacy moves the records written before the store existed, <root>/<name>/config.json
+// with the log next to it, to <root>/containers/<id>, taking their names.
+// A record an interrupted run left half moved is finished.
+func (s *Store) MigrateLegacy() error {
+	legacyDirs, err := s.legacyDirs()
+	if err != nil || len(legacyDirs) == 0 {
+		return err
+	}
+	lock, err := fsutil.LockFile(filepath.Join(s.root, migrateLockName), true)
+	if err != nil {
+		return err
+	}
+	defer fsutil.UnlockFile(lock)
+	// another process may have moved them meanwhile
+	if legacyDirs, err = s.legacyDirs(); err != nil {
+		return err
+	}
+	for _, legacyDir := range legacyDirs {
+		if err := s.migrateLegacyRecord(legacyDir); err != nil {
+			return fmt.Errorf("migrate %s: %v", legacyDir, err)
+		}
+	}
+	return nil
+}
+
+// directories below the root holding a record of the legacy layout
+func (s *Store) legacyDirs() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == "containers" || entry.Name() == "names" {
+			continue
+		}
+		dir := filepath.Join(s.root, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, configName)); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+func (s *Store) migrateLegacyRecord(legacyDir string) error {
+	content, err := ioutil.ReadFile(filepath.Join(legacyDir, configName))
+	if err != nil {
+		return err
+	}
+	containerInfo, err := decode(content)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Id == "" {
+		containerInfo.Id = containerInfo.Name
+	}
+	id := containerInfo.Id
+	if err := os.MkdirAll(s.Dir(id), 0700); err != nil {
+		return err
+	}
+	lock, err := fsutil.LockFile(s.lockPath(id), true)
+	if err != nil {
+		return err
+	}
+	defer fsutil.UnlockFile(lock)
+
+	// the log and whatever else was kept next to the record
+	files, err := ioutil.ReadDir(legacyDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.Name() == configName {
+			continue
+		}
+		if err := os.Rename(filepath.Join(legacyDir, file.Name()), filepath.Join(s.Dir(id), file.Name())); err != nil {
+			return err
+		}
+	}
+	// the name may be taken by now, or by an interrupted run for this record already
+	if owner, err := s.NameOwner(containerInfo.Name); err != nil || owner != id {
+		if err := s.ReserveName(containerInfo.Name, id); err != nil {
+			if containerInfo.Name, err = s.reserveGeneratedName(id); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.write(containerInfo); err != nil {
+		return err
+	}
+	return os.RemoveAll(legacyDir)
+}
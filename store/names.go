@@ -0,0 +1,104 @@
+package store
+
+import (
+	"ToyDocker/container"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// returned, wrapped, when a container name is taken
+var ErrNameInUse = errors.New("container name is already in use")
+
+func (s *Store) namePath(name string) string {
+	return filepath.Join(s.root, "names", name)
+}
+
+// take name for container id. Creating the name file with O_EXCL
+// makes the check and the reservation one atomic step.
+// A name left behind by a container whose record is gone is taken over.
+func (s *Store) ReserveName(name, id string) error {
+	if err := container.ValidateName(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(s.root, "names"), 0700); err != nil {
+		return err
+	}
+	err := s.reserveName(name, id)
+	if err != nil && errors.Is(err, ErrNameInUse) && s.releaseStaleName(name) {
+		err = s.reserveName(name, id)
+	}
+	return err
+}
+
+func (s *Store) reserveName(name, id string) error {
+	file, err := os.OpenFile(s.namePath(name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			owner, _ := s.NameOwner(name)
+			return fmt.Errorf("%w: %s is used by container %s", ErrNameInUse, name, container.ShortID(owner))
+		}
+		return err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(id); err != nil {
+		os.Remove(s.namePath(name))
+		return err
+	}
+	return nil
+}
+
+// reserve a generated name, retrying on collisions
+func (s *Store) reserveGeneratedName(id string) (string, error) {
+	for retry := 0; ; retry++ {
+		// after a few collisions add a number to the generated name
+		name := container.GenerateName(retry / 3)
+		err := s.ReserveName(name, id)
+		if err == nil {
+			return name, nil
+		}
+		if !errors.Is(err, ErrNameInUse) || retry >= 10 {
+			return "", err
+		}
+	}
+}
+
+// free a name whose container record is gone, e.g. after a crash.
+// reports whether the name was freed.
+func (s *Store) releaseStaleName(name string) bool {
+	owner, err := s.NameOwner(name)
+	if err != nil || owner == "" {
+		return false
+	}
+	if _, err := os.Stat(s.Dir(owner)); !os.IsNotExist(err) {
+		return false
+	}
+	return s.ReleaseName(name, owner) == nil
+}
+
+// give name back, only if it still belongs to id
+func (s *Store) ReleaseName(name, id string) error {
+	owner, err := s.NameOwner(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if owner != id {
+		return nil
+	}
+	return os.Remove(s.namePath(name))
+}
+
+// id of the container holding name
+func (s *Store) NameOwner(name string) (string, error) {
+	content, err := ioutil.ReadFile(s.namePath(name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"ToyDocker/container"
+	"ToyDocker/fsutil"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// default location of the state kept for containers
+const DefaultRoot = "/var/run/toy-docker"
+
+const (
+	configName = "config.json"
+	lockName   = "lock"
+)
+
+// Store keeps one record per container in <root>/containers/<id>/config.json
+// and the taken names in <root>/names/<name>.
+// Records are written atomically and guarded by a flock per container,
+// so concurrent toy-docker processes never see half written state.
+// Records of the layout before the store, <root>/<name>/config.json, are moved by MigrateLegacy.
+type Store struct {
+	root string
+}
+
+func New(root string) *Store {
+	return &Store{root: root}
+}
+
+// directory of the container, also holding its log files
+func (s *Store) Dir(id string) string {
+	return filepath.Join(s.root, "containers", id)
+}
+
+func (s *Store) configPath(id string) string {
+	return filepath.Join(s.Dir(id), configName)
+}
+
+func (s *Store) lockPath(id string) string {
+	return filepath.Join(s.Dir(id), lockName)
+}
+
+// add a new container record, taking its name.
+// A readable name is generated when containerInfo.Name is empty.
+func (s *Store) Create(containerInfo *container.ContainerInfo) error {
+	if containerInfo.Id == "" {
+		return fmt.Errorf("container id is empty")
+	}
+	dir := s.Dir(containerInfo.Id)
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return err
+	}
+	// the directory exists before the name is taken,
+	// so the name is never mistaken for a stale one
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+	lock, err := fsutil.LockFile(s.lockPath(containerInfo.Id), true)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	defer fsutil.UnlockFile(lock)
+
+	if containerInfo.Name == "" {
+		containerInfo.Name, err = s.reserveGeneratedName(containerInfo.Id)
+	} else {
+		err = s.ReserveName(containerInfo.Name, containerInfo.Id)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if err := s.write(containerInfo); err != nil {
+		s.ReleaseName(containerInfo.Name, containerInfo.Id)
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) write(containerInfo *container.ContainerInfo) error {
+	containerInfo.SchemaVersion = SchemaVersion
+	content, err := json.MarshalIndent(containerInfo, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(s.configPath(containerInfo.Id), content, 0600)
+}
+
+func (s *Store) read(id string) (*container.ContainerInfo, error) {
+	content, err := ioutil.ReadFile(s.configPath(id))
+	if err != nil {
+		return nil, err
+	}
+	containerInfo, err := decode(content)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %v", s.configPath(id), err)
+	}
+	return containerInfo, nil
+}
+
+// read the record of container id.
+// A missing container gives an error satisfying os.IsNotExist.
+func (s *Store) Get(id string) (*container.ContainerInfo, error) {
+	lock, err := fsutil.LockFile(s.lockPath(id), false)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+	return s.read(id)
+}
+
+// read the records of all containers
+func (s *Store) List() ([]*container.ContainerInfo, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.root, "containers"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var containers []*container.ContainerInfo
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		containerInfo, err := s.Get(file.Name())
+		if err != nil {
+			// removed while we were listing
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		containers = append(containers, containerInfo)
+	}
+	return containers, nil
+}
+
+// change the record of container id under its lock.
+// Nothing is written when fn returns an error.
+func (s *Store) Update(id string, fn func(containerInfo *container.ContainerInfo) error) (*container.ContainerInfo, error) {
+	lock, err := fsutil.LockFile(s.lockPath(id), true)
+	if err != nil {
+		return nil, err
+	}
+	defer fsutil.UnlockFile(lock)
+	containerInfo, err := s.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(containerInfo); err != nil {
+		return nil, err
+	}
+	// the id names the record, it never changes
+	containerInfo.Id = id
+	if err := s.write(containerInfo); err != nil {
+		return nil, err
+	}
+	return containerInfo, nil
+}
+
+// remove the record of container id, its log files and its name
+func (s *Store) Delete(id string) error {
+	lock, err := fsutil.LockFile(s.lockPath(id), true)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer fsutil.UnlockFile(lock)
+	containerInfo, err := s.read(id)
+	if err == nil {
+		if err := s.ReleaseName(containerInfo.Name, id); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(s.Dir(id))
+}
+
+// give container id a new name
+func (s *Store) Rename(id, newName string) error {
+	var oldName string
+	_, err := s.Update(id, func(containerInfo *container.ContainerInfo) error {
+		if containerInfo.Name == newName {
+			return fmt.Errorf("container is already named %s", newName)
+		}
+		if err := s.ReserveName(newName, id); err != nil {
+			return err
+		}
+		oldName = containerInfo.Name
+		containerInfo.Name = newName
+		return nil
+	})
+	if err != nil {
+		if oldName != "" {
+			s.ReleaseName(newName, id)
+		}
+		return err
+	}
+	return s.ReleaseName(oldName, id)
+}
+
+// find the container a command argument refers to:
+// its full id, its name, or a prefix of exactly one id
+func (s *Store) Resolve(ref string) (*container.ContainerInfo, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("empty container name or id")
+	}
+	containers, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range containers {
+		if item.Id == ref {
+			return item, nil
+		}
+	}
+	for _, item := range containers {
+		if item.Name == ref {
+			return item, nil
+		}
+	}
+	var found *container.ContainerInfo
+	for _, item := range containers {
+		if strings.HasPrefix(item.Id, ref) {
+			if found != nil {
+				return nil, fmt.Errorf("container id prefix %s is ambiguous", ref)
+			}
+			found = item
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no such container: %s", ref)
+	}
+	return found, nil
+}
@@ -0,0 +1,290 @@
+package store
+
+import (
+	"ToyDocker/container"
+	"ToyDocker/logdriver"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newContainer(t *testing.T, s *Store, name string) *container.ContainerInfo {
+	id, err := container.GenerateID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerInfo := &container.ContainerInfo{Id: id, Name: name, Status: container.CREATED}
+	if err := s.Create(containerInfo); err != nil {
+		t.Fatal(err)
+	}
+	return containerInfo
+}
+
+func TestCreateGetUpdateDelete(t *testing.T) {
+	s := New(t.TempDir())
+	created := newContainer(t, s, "web")
+
+	got, err := s.Get(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "web" || got.SchemaVersion != SchemaVersion {
+		t.Errorf("record %+v, want name web at schema %d", got, SchemaVersion)
+	}
+
+	if _, err := s.Update(created.Id, func(info *container.ContainerInfo) error {
+		info.Status = container.RUNNING
+		info.Id = "changed"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Get(created.Id); got.Status != container.RUNNING || got.Id != created.Id {
+		t.Errorf("updated record %+v", got)
+	}
+
+	// a failing update writes nothing
+	if _, err := s.Update(created.Id, func(info *container.ContainerInfo) error {
+		info.Status = container.EXIT
+		return errors.New("no")
+	}); err == nil {
+		t.Error("failing update succeeded")
+	}
+	if got, _ := s.Get(created.Id); got.Status != container.RUNNING {
+		t.Errorf("failed update changed status to %s", got.Status)
+	}
+
+	if err := s.Delete(created.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(created.Id); !os.IsNotExist(err) {
+		t.Errorf("deleted record gives %v", err)
+	}
+	// the name is free again
+	newContainer(t, s, "web")
+}
+
+func TestNames(t *testing.T) {
+	s := New(t.TempDir())
+	first := newContainer(t, s, "web")
+
+	id, _ := container.GenerateID()
+	err := s.Create(&container.ContainerInfo{Id: id, Name: "web"})
+	if !errors.Is(err, ErrNameInUse) {
+		t.Errorf("second container named web gives %v", err)
+	}
+	if _, err := os.Stat(s.Dir(id)); !os.IsNotExist(err) {
+		t.Error("the refused container left its directory")
+	}
+
+	generated := newContainer(t, s, "")
+	if generated.Name == "" {
+		t.Error("no name was generated")
+	}
+
+	if err := s.Rename(first.Id, generated.Name); !errors.Is(err, ErrNameInUse) {
+		t.Errorf("rename to a taken name gives %v", err)
+	}
+	if err := s.Rename(first.Id, "api"); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _ := s.NameOwner("api"); owner != first.Id {
+		t.Errorf("api is owned by %s", owner)
+	}
+	if _, err := s.NameOwner("web"); !os.IsNotExist(err) {
+		t.Error("the old name was kept")
+	}
+
+	// a name whose record vanished in a crash is taken over
+	if err := os.RemoveAll(s.Dir(first.Id)); err != nil {
+		t.Fatal(err)
+	}
+	newContainer(t, s, "api")
+}
+
+// of many processes creating the same name at once exactly one wins
+func TestConcurrentNames(t *testing.T) {
+	s := New(t.TempDir())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, _ := container.GenerateID()
+			if s.Create(&container.ContainerInfo{Id: id, Name: "web"}) == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Errorf("%d containers got the name web", created)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	s := New(t.TempDir())
+	a := &container.ContainerInfo{Id: "abc111", Name: "alpha"}
+	b := &container.ContainerInfo{Id: "abc222", Name: "abc111x"}
+	for _, c := range []*container.ContainerInfo{a, b} {
+		if err := s.Create(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"abc111", "abc111"},
+		{"alpha", "abc111"},
+		{"abc111x", "abc222"},
+		{"abc2", "abc222"},
+		{"abc", ""},
+		{"zzz", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		got, err := s.Resolve(test.ref)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q resolved to %s", test.ref, got.Id)
+			}
+			continue
+		}
+		if err != nil || got.Id != test.want {
+			t.Errorf("%q resolved to %v %v, want %s", test.ref, got, err, test.want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		record    string
+		name      string
+		logDriver string
+		invalid   bool
+	}{
+		// written before the store, no version, driver or name
+		{record: `{"id": "1234567890", "status": "running"}`, name: "1234567890", logDriver: logdriver.DefaultDriver},
+		{record: `{"id": "1234567890", "name": "web"}`, name: "web", logDriver: logdriver.DefaultDriver},
+		{record: `{"schemaVersion": 1, "id": "1", "name": "web", "logDriver": "local"}`, name: "web", logDriver: "local"},
+		{record: `{"status": "running"}`, invalid: true},
+		{record: `{"schemaVersion": 99, "id": "1"}`, invalid: true},
+		{record: `not json`, invalid: true},
+	}
+	for _, test := range tests {
+		got, err := decode([]byte(test.record))
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s decoded", test.record)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.record, err)
+			continue
+		}
+		if got.Name != test.name || got.LogDriver != test.logDriver || got.SchemaVersion != SchemaVersion {
+			t.Errorf("%s decoded to name %s driver %s version %d", test.record, got.Name, got.LogDriver, got.SchemaVersion)
+		}
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	root := t.TempDir()
+	s := New(root)
+	taken := newContainer(t, s, "taken")
+	legacy := map[string]string{
+		"web":   `{"id": "1234567890", "name": "web", "status": "exited", "command": "top"}`,
+		"taken": `{"id": "0987654321", "name": "taken", "status": "exited"}`,
+	}
+	for name, record := range legacy {
+		dir := filepath.Join(root, name)
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, configName), []byte(record), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, container.ContainerLogFile), []byte("hello\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.MigrateLegacy(); err != nil {
+		t.Fatal(err)
+	}
+	web, err := s.Resolve("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if web.Id != "1234567890" || web.Command != "top" || web.LogDriver != logdriver.DefaultDriver {
+		t.Errorf("migrated record %+v", web)
+	}
+	if content, err := os.ReadFile(filepath.Join(s.Dir(web.Id), container.ContainerLogFile)); err != nil || string(content) != "hello\n" {
+		t.Errorf("log not moved: %q %v", content, err)
+	}
+	// a name taken since gets replaced by a generated one
+	other, err := s.Get("0987654321")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Name == "taken" || other.Name == "" {
+		t.Errorf("migrated record named %q, taken by %s", other.Name, taken.Id)
+	}
+	for name := range legacy {
+		if _, err := os.Stat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Errorf("legacy directory %s is left", name)
+		}
+	}
+	containers, err := s.List()
+	if err != nil || len(containers) != 3 {
+		t.Errorf("%d containers listed, want 3: %v", len(containers), err)
+	}
+
+	// nothing left to do the second time
+	if err := s.MigrateLegacy(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a run interrupted after taking the name and moving the log is finished
+func TestMigrateLegacyInterrupted(t *testing.T) {
+	root := t.TempDir()
+	s := New(root)
+	id := "1234567890"
+	dir := filepath.Join(root, "web")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, configName), []byte(`{"id": "`+id+`", "name": "web"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(s.Dir(id), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReserveName("web", id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.MigrateLegacy(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Resolve("web")
+	if err != nil || got.Id != id {
+		t.Fatalf("resolved web to %v %v", got, err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("legacy directory is left")
+	}
+	if names, _ := os.ReadDir(filepath.Join(root, "names")); len(names) != 1 || !strings.HasPrefix(names[0].Name(), "web") {
+		t.Errorf("names %v, want only web", names)
+	}
+}